@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Actions --------------------------------------------------------------------
+
+// Handlers for the actions of displayed notifications.
+var actionHandlers = make(map[notificationRef]map[string]func())
+var actionsMutex sync.Mutex
+
+// Register the action handlers for a notification.
+// Replaces the handlers from a previous notification with the same ID.
+func setActions(id notificationRef, handlers map[string]func()) {
+	actionsMutex.Lock()
+	defer actionsMutex.Unlock()
+	if len(handlers) == 0 {
+		delete(actionHandlers, id)
+	} else {
+		actionHandlers[id] = handlers
+	}
+}
+
+// Forget the action handlers for a notification which was closed.
+func forgetActions(id notificationRef) {
+	actionsMutex.Lock()
+	defer actionsMutex.Unlock()
+	delete(actionHandlers, id)
+}
+
+// Run the handler for an action the user invoked on a notification.
+func invokeAction(id notificationRef, action string) {
+	actionsMutex.Lock()
+	handler, ok := actionHandlers[id][action]
+	actionsMutex.Unlock()
+	if ok {
+		// do not block the signal handler
+		go handler()
+	}
+}
+
+// Put text onto the clipboard,
+// using wl-copy on Wayland and xclip on X11.
+func copyToClipboard(text string) {
+	var cmd *exec.Cmd
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmd = exec.Command("wl-copy")
+	} else {
+		cmd = exec.Command("xclip", "-selection", "clipboard")
+	}
+	cmd.Stdin = strings.NewReader(text)
+
+	err := cmd.Run()
+	if err != nil {
+		log.Printf("ERROR: Failed to copy to clipboard: %v", err)
+	}
+}
+
+// Check that a path from a message is a file in one of the `allowed_dirs`.
+// Returns the path with symlinks resolved.
+func checkPath(path string) (string, error) {
+	config := currentConfig()
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("Not an absolute path: %q", path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range config.AllowedDirs {
+		dir, err = filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("Path %q is not in allowed_dirs", path)
+}
+
+// Check that a link is an HTTP(S) URL.
+func checkLink(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("Not an HTTP link: %q", link)
+	}
+	return u.String(), nil
+}
+
+// Open a file or link with the default application.
+func openFile(path string) {
+	err := exec.Command("xdg-open", path).Run()
+	if err != nil {
+		log.Printf("ERROR: Failed to open %v: %v", path, err)
+	}
+}
+
+// Write the complete payload of a message to a file in the user's
+// cache directory, for messages which were truncated.
+// JSON is indented for readability.
+func saveFullMessage(ctx *TemplateContext) (string, error) {
+	dir, err := cacheDir("messages")
+	if err != nil {
+		return "", err
+	}
+
+	data := []byte(ctx.payload)
+	ext := ".txt"
+	if ctx.ContentType() == contentTypeJSON {
+		var indented bytes.Buffer
+		if json.Indent(&indented, data, "", "    ") == nil {
+			data = indented.Bytes()
+			ext = ".json"
+		}
+	}
+
+	sum := sha1.Sum(data)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])+ext)
+	return path, writeFileAtomic(path, data)
+}
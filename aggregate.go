@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Aggregation ----------------------------------------------------------------
+
+// Configuration for subscriptions which summarize values over a time window.
+type AggregateConfig struct {
+	Window      int  `json:"window"`        // seconds
+	FlushOnExit bool `json:"flush_on_exit"` // show open windows on shutdown
+}
+
+// Summary of the values received within one window.
+type Summary struct {
+	Min   float64
+	Max   float64
+	Avg   float64
+	Last  float64
+	Count int
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("min %v, max %v, avg %v, last %v (%d values)",
+		round(s.Min), round(s.Max), round(s.Avg), round(s.Last), s.Count)
+}
+
+// Values collected for one topic during the current window.
+type aggregation struct {
+	summary Summary
+	sum     float64
+	last    *TemplateContext
+}
+
+// Round to two decimals for display.
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// Add the value from a message to the current window for its topic.
+// Starts a new window if there is none.
+func (s *Subscription) aggregate(ctx *TemplateContext) {
+	value, err := s.value(ctx)
+	if err != nil {
+		log.Printf("WARNING: Ignoring non-numeric value on %v: %v", ctx.topic, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.aggregations == nil {
+		s.aggregations = make(map[string]*aggregation)
+	}
+	a, ok := s.aggregations[ctx.topic]
+	if !ok {
+		a = &aggregation{summary: Summary{Min: value, Max: value}}
+		s.aggregations[ctx.topic] = a
+
+		topic := ctx.topic
+		window := time.Duration(s.Aggregate.Window) * time.Second
+		time.AfterFunc(window, func() {
+			s.flushAggregation(topic)
+		})
+	}
+
+	a.summary.Min = math.Min(a.summary.Min, value)
+	a.summary.Max = math.Max(a.summary.Max, value)
+	a.summary.Last = value
+	a.summary.Count++
+	a.sum += value
+	a.last = ctx
+}
+
+// Close the window for a topic and show the summary.
+func (s *Subscription) flushAggregation(topic string) {
+	s.mutex.Lock()
+	a, ok := s.aggregations[topic]
+	delete(s.aggregations, topic)
+	s.mutex.Unlock()
+
+	if !ok || a.summary.Count == 0 {
+		return
+	}
+
+	a.summary.Avg = a.sum / float64(a.summary.Count)
+	ctx := a.last
+	ctx.summary = &a.summary
+	defer s.recoverPanic(topic, ctx.payload)
+	s.show(ctx)
+}
+
+// Close all open windows and show their summaries.
+func (s *Subscription) flushAggregations() {
+	s.mutex.Lock()
+	topics := make([]string, 0, len(s.aggregations))
+	for topic := range s.aggregations {
+		topics = append(topics, topic)
+	}
+	s.mutex.Unlock()
+
+	for _, topic := range topics {
+		s.flushAggregation(topic)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Availability ---------------------------------------------------------------
+//
+// A retained status message tells other clients whether notifications
+// can be shown on this machine. The client publishes `online` when it
+// connects and registers `offline` as its Last Will, which the broker
+// publishes when the connection is lost.
+// The will is not sent for a clean disconnect, so `offline` is published
+// explicitly before disconnecting.
+
+const availabilityTimeout = 2 * time.Second
+
+// Topic and payloads for the availability status.
+type AvailabilityConfig struct {
+	Topic   string `json:"topic"`
+	Online  string `json:"online"`
+	Offline string `json:"offline"`
+}
+
+// Set defaults and check for invalid values.
+func (a *AvailabilityConfig) validate() error {
+	if a.Topic == "" {
+		return errors.New("availability.topic: required")
+	}
+	if strings.ContainsAny(a.Topic, "+#") {
+		return errors.New("availability.topic: must not contain wildcards")
+	}
+	if a.Online == "" {
+		a.Online = "online"
+	}
+	if a.Offline == "" {
+		a.Offline = "offline"
+	}
+	return nil
+}
+
+// Register the Last Will with the client options.
+func (a *AvailabilityConfig) apply(opts *mqtt.ClientOptions) {
+	config := currentConfig()
+	opts.SetWill(a.Topic, a.Offline, byte(config.QoS), true)
+}
+
+// Publish the availability status as a retained message.
+func publishAvailability(client mqtt.Client, online bool) {
+	config := currentConfig()
+	a := config.Availability
+	if a == nil {
+		return
+	}
+	payload := a.Offline
+	if online {
+		payload = a.Online
+	}
+	t := client.Publish(a.Topic, byte(config.QoS), true, payload)
+	if !t.WaitTimeout(availabilityTimeout) {
+		log.Printf("WARNING: Timed out publishing availability to %q", a.Topic)
+		return
+	}
+	if t.Error() != nil {
+		log.Printf("ERROR: Failed to publish availability: %v", t.Error())
+	}
+}
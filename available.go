@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Service Availability -------------------------------------------------------
+
+// Whether the notifications service has an owner on the session bus.
+var serviceAvailable = true
+
+// Limits for retrying the activation of the notifications service.
+const maxActivationAttempts = 5
+const maxActivationBackoff = time.Minute
+
+// Messages received while the service was not available.
+var waitingQueue = make([]queuedMessage, 0)
+var availableMutex sync.Mutex
+
+// Whether `activateService` is running, so that it runs only once.
+var activating = false
+
+// Check if the notifications service is running.
+// If not, messages are held back until it appears.
+func checkService() error {
+	var hasOwner bool
+	err := dbusConn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0,
+		DESTINATION).Store(&hasOwner)
+	if err != nil {
+		return err
+	}
+
+	if !hasOwner {
+		log.Printf("Waiting for %v to appear...", DESTINATION)
+	}
+	// starts the activation if the service is not available
+	setServiceAvailable(hasOwner)
+	return nil
+}
+
+// Ask the session bus to start the notifications service (D-Bus activation),
+// for setups where nothing else starts it.
+// Retries with backoff until the service is available.
+// Only started from `setServiceAvailable`.
+func activateService() {
+	defer func() {
+		availableMutex.Lock()
+		activating = false
+		availableMutex.Unlock()
+	}()
+
+	backoff := time.Second
+	for attempt := 1; attempt <= maxActivationAttempts; attempt++ {
+		availableMutex.Lock()
+		available := serviceAvailable
+		availableMutex.Unlock()
+		if available {
+			return
+		}
+
+		var result uint32
+		err := dbusConn.BusObject().Call("org.freedesktop.DBus.StartServiceByName",
+			0, DESTINATION, uint32(0)).Store(&result)
+		if err == nil {
+			log.Printf("Started %v", DESTINATION)
+			return
+		}
+		if dbusErr, ok := err.(dbus.Error); ok && dbusErr.Name == "org.freedesktop.DBus.Error.ServiceUnknown" {
+			log.Printf("No activatable service for %v", DESTINATION)
+			return
+		}
+
+		log.Printf("WARNING: Failed to start %v (attempt %d): %v",
+			DESTINATION, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxActivationBackoff {
+			backoff = maxActivationBackoff
+		}
+	}
+}
+
+// Called when the notifications service appears or disappears.
+// Dispatches held back messages once the service is available.
+func setServiceAvailable(available bool) {
+	availableMutex.Lock()
+	if available == serviceAvailable {
+		availableMutex.Unlock()
+		return
+	}
+	serviceAvailable = available
+	queue := waitingQueue
+	waitingQueue = make([]queuedMessage, 0)
+	activate := !available && !activating
+	if activate {
+		activating = true
+	}
+	availableMutex.Unlock()
+
+	if !available {
+		log.Printf("%v disappeared, holding back messages", DESTINATION)
+		if activate {
+			go activateService()
+		}
+		return
+	}
+
+	log.Printf("%v is available, %d messages waiting", DESTINATION, len(queue))
+	// do not block the signal handler with D-Bus calls
+	go func() {
+		for _, m := range queue {
+			enqueue(m)
+		}
+	}()
+}
+
+// Keep a message for later if the notifications service is not available.
+// Returns true if the message was held back.
+func holdUntilAvailable(m queuedMessage) bool {
+	availableMutex.Lock()
+	defer availableMutex.Unlock()
+	if serviceAvailable {
+		return false
+	}
+
+	if len(waitingQueue) < maxQueued {
+		waitingQueue = append(waitingQueue, m)
+	} else {
+		log.Printf("WARNING: Dropping message on %v, too many waiting", m.Topic)
+		recordError()
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Badges ---------------------------------------------------------------------
+//
+// Subscriptions without an icon can show a generated badge instead:
+// a colored circle with the first letter of a text, e.g. a topic segment.
+// The color is derived from the text, so each source keeps its color.
+// The image is sent as `image-data`, no file is written.
+
+const badgeSize = 48
+const badgeScale = 4 // pixels per font pixel
+
+// A 5x7 pixel font for the letters on badges.
+var badgeFont = map[rune][7]string{
+	'A': {" ### ", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'B': {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'C': {" ### ", "#   #", "#    ", "#    ", "#    ", "#   #", " ### "},
+	'D': {"#### ", "#   #", "#   #", "#   #", "#   #", "#   #", "#### "},
+	'E': {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#####"},
+	'F': {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "},
+	'G': {" ### ", "#   #", "#    ", "# ###", "#   #", "#   #", " ####"},
+	'H': {"#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'I': {" ### ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'J': {"  ###", "   # ", "   # ", "   # ", "   # ", "#  # ", " ##  "},
+	'K': {"#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"},
+	'L': {"#    ", "#    ", "#    ", "#    ", "#    ", "#    ", "#####"},
+	'M': {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'N': {"#   #", "#   #", "##  #", "# # #", "#  ##", "#   #", "#   #"},
+	'O': {" ### ", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'P': {"#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "},
+	'Q': {" ### ", "#   #", "#   #", "#   #", "# # #", "#  # ", " ## #"},
+	'R': {"#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"},
+	'S': {" ####", "#    ", "#    ", " ### ", "    #", "    #", "#### "},
+	'T': {"#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'U': {"#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'V': {"#   #", "#   #", "#   #", "#   #", "#   #", " # # ", "  #  "},
+	'W': {"#   #", "#   #", "#   #", "# # #", "# # #", "# # #", " # # "},
+	'X': {"#   #", "#   #", " # # ", "  #  ", " # # ", "#   #", "#   #"},
+	'Y': {"#   #", "#   #", " # # ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'Z': {"#####", "    #", "   # ", "  #  ", " #   ", "#    ", "#####"},
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+}
+
+// The `image-data` hint for a badge with the first letter of the text.
+func badgeImage(text string) dbus.Variant {
+	r, g, b := badgeColor(text)
+	pixels := make([]byte, badgeSize*badgeSize*4)
+
+	// the circle
+	center := float64(badgeSize-1) / 2
+	for y := 0; y < badgeSize; y++ {
+		for x := 0; x < badgeSize; x++ {
+			if math.Hypot(float64(x)-center, float64(y)-center) <= center {
+				copy(pixels[(y*badgeSize+x)*4:], []byte{r, g, b, 255})
+			}
+		}
+	}
+
+	// the letter, centered
+	glyph, ok := badgeFont[badgeLetter(text)]
+	if ok {
+		left := (badgeSize - 5*badgeScale) / 2
+		top := (badgeSize - 7*badgeScale) / 2
+		for row, line := range glyph {
+			for col, c := range line {
+				if c != '#' {
+					continue
+				}
+				for dy := 0; dy < badgeScale; dy++ {
+					for dx := 0; dx < badgeScale; dx++ {
+						x := left + col*badgeScale + dx
+						y := top + row*badgeScale + dy
+						copy(pixels[(y*badgeSize+x)*4:], []byte{255, 255, 255, 255})
+					}
+				}
+			}
+		}
+	}
+
+	// width, height, rowstride, has alpha, bits per sample, channels, data
+	return dbus.MakeVariant(struct {
+		Width, Height, Rowstride int32
+		HasAlpha                 bool
+		BitsPerSample, Channels  int32
+		Data                     []byte
+	}{badgeSize, badgeSize, badgeSize * 4, true, 8, 4, pixels})
+}
+
+// The first letter or digit of the text, upper case.
+func badgeLetter(text string) rune {
+	for _, c := range strings.ToUpper(text) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			return c
+		}
+	}
+	return ' '
+}
+
+// A color for the text, always the same for the same text.
+// The hue comes from a hash of the text, with fixed saturation and value
+// so that white letters are readable.
+func badgeColor(text string) (byte, byte, byte) {
+	h := fnv.New32a()
+	h.Write([]byte(text))
+	hue := float64(h.Sum32()%360) / 60
+	const s, v = 0.6, 0.75
+
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := v - c
+	return byte((r + m) * 255), byte((g + m) * 255), byte((b + m) * 255)
+}
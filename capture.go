@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Capture and Replay ---------------------------------------------------------
+//
+// With `-capture FILE`, all incoming messages which match a subscription
+// are written to FILE, one JSON object per line.
+// With `-replay FILE`, the messages from such a file are dispatched
+// instead of connecting to the MQTT broker.
+
+// A recorded message.
+type capturedMessage struct {
+	Time    time.Time `json:"time"`
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+	// for payloads which are not valid UTF-8
+	Binary []byte `json:"binary,omitempty"`
+}
+
+var captureFile *os.File
+var captureEncoder *json.Encoder
+var captureMutex sync.Mutex
+
+// Open the capture file for appending.
+func openCapture(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	captureFile = f // global
+	captureEncoder = json.NewEncoder(f)
+	log.Printf("Capturing messages to %v", path)
+	return nil
+}
+
+// Close the capture file.
+func closeCapture() {
+	captureMutex.Lock()
+	defer captureMutex.Unlock()
+	if captureFile != nil {
+		captureFile.Close()
+		captureFile = nil
+	}
+}
+
+// Record a message, if capturing is enabled.
+func capture(topic, payload string) {
+	captureMutex.Lock()
+	defer captureMutex.Unlock()
+	if captureFile == nil {
+		return
+	}
+
+	m := capturedMessage{Time: time.Now(), Topic: topic}
+	if utf8.ValidString(payload) {
+		m.Payload = payload
+	} else {
+		m.Binary = []byte(payload)
+	}
+	err := captureEncoder.Encode(m)
+	if err != nil {
+		log.Printf("ERROR: Failed to capture message: %v", err)
+	}
+}
+
+// Dispatch all messages from a capture file.
+func replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxPayloadSize)
+	count := 0
+	for scanner.Scan() {
+		var m capturedMessage
+		err = json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			return err
+		}
+
+		payload := m.Payload
+		if m.Binary != nil {
+			payload = string(m.Binary)
+		}
+		dispatch(queuedMessage{Topic: m.Topic, Payload: payload})
+		count++
+	}
+	log.Printf("Replayed %d messages from %v", count, path)
+	return scanner.Err()
+}
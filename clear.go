@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Clearing Notifications -----------------------------------------------------
+//
+// With `clear_when`, a message can close the notification for its topic
+// instead of showing a new one, e.g. "door closed" after "door open".
+
+// Whether the message should close the notification for its topic.
+func (s *Subscription) clears(ctx *TemplateContext) bool {
+	if s.clearPattern == nil {
+		return false
+	}
+	value, err := s.textValue(ctx)
+	if err != nil {
+		log.Printf("WARNING: Cannot check clear_when on %v: %v", ctx.topic, err)
+		return false
+	}
+	return s.clearPattern.MatchString(strings.TrimSpace(value))
+}
+
+// The value of a message as text.
+// Either the payload itself or the `value_field` from a JSON payload.
+func (s *Subscription) textValue(ctx *TemplateContext) (string, error) {
+	if s.ValueField == "" {
+		return ctx.payload, nil
+	}
+
+	data, err := ctx.JSON()
+	if err != nil {
+		return "", err
+	}
+	v, ok := lookupField(data, s.ValueField)
+	if !ok {
+		return "", missingFieldError(s.ValueField)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// Close the displayed notifications for a topic.
+func (s *Subscription) clear(topic string) {
+	if *systemMode {
+		log.Printf("WARNING: Cannot close notifications in system mode")
+		return
+	}
+
+	for _, ref := range displayedFor(s.replaceKey(topic)) {
+		server := dbusConn.Object(ref.Server, OBJ_PATH)
+		call := server.Call("org.freedesktop.Notifications.CloseNotification", 0, ref.ID)
+		if call.Err != nil {
+			log.Printf("ERROR: Failed to close notification %d: %v", ref.ID, call.Err)
+		}
+	}
+}
+
+// The `value_field` is not in the payload.
+type missingFieldError string
+
+func (e missingFieldError) Error() string {
+	return fmt.Sprintf("No field %q in payload", string(e))
+}
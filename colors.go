@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Colors ---------------------------------------------------------------------
+//
+// Some notifications servers (e.g. dunst) accept colors as hints:
+// `fgcolor` for the text, `bgcolor` for the background
+// and `frcolor` for the frame. The options are templates,
+// so the color can depend on the message, e.g. red for critical alerts.
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// Render the color templates and set the color hints.
+// Empty results are left out.
+func (s *Subscription) applyColors(n *Notification, ctx *TemplateContext) {
+	n.Colors = make(map[string]string)
+	for hint, raw := range map[string]string{
+		"fgcolor": s.FgColor,
+		"bgcolor": s.BgColor,
+		"frcolor": s.FrColor,
+	} {
+		if raw == "" {
+			continue
+		}
+		color, err := s.templates.render(hint, raw, ctx)
+		if err != nil {
+			log.Printf("ERROR: Failed to render %v template: %v", hint, err)
+			continue
+		}
+		color = strings.TrimSpace(color)
+		if color == "" {
+			continue
+		}
+		if !colorPattern.MatchString(color) {
+			log.Printf("WARNING: Ignoring invalid %v %q, expected #rrggbb", hint, color)
+			continue
+		}
+		n.Colors[hint] = color
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Composite Subscriptions ----------------------------------------------------
+//
+// A composite subscription has several `topics` instead of a single `topic`.
+// It shows one notification which is replaced whenever any of the topics
+// receives a message, with access to the latest value of each topic.
+
+// Whether the subscription matches the given topic.
+func (s *Subscription) matches(topic string) bool {
+	if s.Topic != "" && topicMatches(s.Topic, topic) {
+		return true
+	}
+	for _, filter := range s.Topics {
+		if topicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether this is a composite subscription.
+func (s *Subscription) composite() bool {
+	return len(s.Topics) > 0
+}
+
+// A name for the subscription in logs and statistics,
+// the `name` if it has one, the topic filter otherwise.
+func (s *Subscription) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.filter()
+}
+
+// The topic filter, or the filters of a composite subscription.
+func (s *Subscription) filter() string {
+	if s.composite() {
+		return strings.Join(s.Topics, ", ")
+	}
+	return s.Topic
+}
+
+// Remember the latest payload for one of the topics
+// and return a copy of the latest payloads for all topics.
+func (s *Subscription) updateValues(topic, payload string) map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.memberValues == nil {
+		s.memberValues = make(map[string]string)
+	}
+	s.memberValues[topic] = payload
+
+	values := make(map[string]string, len(s.memberValues))
+	for t, p := range s.memberValues {
+		values[t] = p
+	}
+	return values
+}
+
+// The latest payload for a topic of a composite subscription.
+// Empty if no message was received for the topic.
+func (t *TemplateContext) Values(topic string) (string, error) {
+	if t.values == nil {
+		return "", errors.New("No values, subscription is not composite")
+	}
+	return t.values[topic], nil
+}
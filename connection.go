@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Connection -----------------------------------------------------------------
+//
+// Options for how the MQTT client keeps the connection to the broker,
+// grouped in the `connection` section of the configuration.
+
+// How the MQTT client connects and reconnects.
+// Intervals are in seconds.
+type ConnectionConfig struct {
+	AutoReconnect        bool   `json:"auto_reconnect"`         // reconnect after the connection was lost
+	MaxReconnectInterval int    `json:"max_reconnect_interval"` // longest wait between reconnect attempts
+	ConnectRetry         bool   `json:"connect_retry"`          // keep trying if the first connect fails
+	ConnectRetryInterval int    `json:"connect_retry_interval"` // wait between connect attempts
+	ResumeSubs           bool   `json:"resume_subs"`            // resume stored subscriptions after reconnect
+	Resubscribe          bool   `json:"resubscribe"`            // subscribe to all topics again after reconnect
+	KeepAlive            int    `json:"keep_alive"`             // interval for keepalive pings
+	Store                string `json:"store"`                  // directory for in-flight messages, in memory if empty
+	DisconnectWait       uint   `json:"disconnect_wait"`        // milliseconds to finish work when disconnecting
+}
+
+func defaultConnection() ConnectionConfig {
+	return ConnectionConfig{
+		AutoReconnect:        true,
+		MaxReconnectInterval: 600,
+		ConnectRetry:         true,
+		ConnectRetryInterval: 30,
+		Resubscribe:          true,
+		KeepAlive:            30,
+		DisconnectWait:       250,
+	}
+}
+
+// Check the connection options for invalid values.
+func (c *ConnectionConfig) validate() error {
+	if c.MaxReconnectInterval <= 0 {
+		return errors.New("connection.max_reconnect_interval: must be positive")
+	}
+	if c.ConnectRetryInterval <= 0 {
+		return errors.New("connection.connect_retry_interval: must be positive")
+	}
+	if c.KeepAlive <= 0 {
+		return errors.New("connection.keep_alive: must be positive")
+	}
+	return nil
+}
+
+// Set the connection options for the MQTT client.
+func (c *ConnectionConfig) apply(opts *mqtt.ClientOptions) {
+	opts.SetAutoReconnect(c.AutoReconnect)
+	opts.SetMaxReconnectInterval(time.Duration(c.MaxReconnectInterval) * time.Second)
+	opts.SetConnectRetry(c.ConnectRetry)
+	opts.SetConnectRetryInterval(time.Duration(c.ConnectRetryInterval) * time.Second)
+	opts.SetResumeSubs(c.ResumeSubs)
+	opts.SetKeepAlive(time.Duration(c.KeepAlive) * time.Second)
+	if c.Store != "" {
+		opts.SetStore(mqtt.NewFileStore(c.Store))
+	}
+}
+
+// Broker Status --------------------------------------------------------------
+//
+// Counters for the connection to the broker, for log lines
+// and the `status` command.
+
+type brokerStatus struct {
+	url         string
+	connected   bool
+	connects    int64 // including reconnects
+	disconnects int64 // lost connections
+	lastError   string
+	since       time.Time // last connect or disconnect
+}
+
+var broker = brokerStatus{}
+var brokerMutex sync.Mutex
+
+// Set the address of the broker, before connecting.
+func setBrokerURL(url string) {
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+	broker.url = url
+}
+
+// Label for log lines about the broker.
+func brokerLabel() string {
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+	return broker.url
+}
+
+// Record a connect. Returns true if it is a reconnect.
+func recordConnect() bool {
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+	broker.connected = true
+	broker.connects++
+	broker.since = time.Now()
+	return broker.connects > 1
+}
+
+// Record a lost connection.
+func recordDisconnect(err error) {
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+	broker.connected = false
+	broker.disconnects++
+	broker.since = time.Now()
+	if err != nil {
+		broker.lastError = err.Error()
+	}
+}
+
+// Get a copy of the broker status.
+func brokerInfo() brokerStatus {
+	brokerMutex.Lock()
+	defer brokerMutex.Unlock()
+	return broker
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	dbus "github.com/godbus/dbus"
+)
+
+// Display Targets ------------------------------------------------------------
+//
+// A subscription can ask for a position on the screen (for servers which
+// support the `x` and `y` hints) or send its notifications to a different
+// notifications server, e.g. a dashboard on a second monitor
+// which implements the notifications interface under another bus name.
+
+// Position of a notification on the screen, in pixels.
+type Position struct {
+	X int32 `json:"x"`
+	Y int32 `json:"y"`
+}
+
+// A notification, identified by the server which shows it and its ID.
+// Each server numbers its notifications independently,
+// so the ID alone does not tell which notification a signal is about.
+type notificationRef struct {
+	Server string // unique bus name of the server, like ":1.42"
+	ID     uint32
+}
+
+// The unique bus name of the notifications server with the given name,
+// which is the sender of its signals.
+// Returns the name itself if it has no owner.
+func serverOwner(name string) string {
+	if name == "" {
+		name = DESTINATION
+	}
+	if dbusConn == nil {
+		return name
+	}
+	var owner string
+	err := dbusConn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner)
+	if err != nil {
+		return name
+	}
+	return owner
+}
+
+// The notifications server with the given bus name,
+// the default server if the name is empty.
+func notificationServer(name string) dbus.BusObject {
+	if name == "" {
+		return notifications
+	}
+	return dbusConn.Object(name, OBJ_PATH)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Emoji ----------------------------------------------------------------------
+
+// Emoji by shortcode, a selection of the ones supported by ntfy and GitHub.
+var emojis = map[string]string{
+	"+1":                 "👍",
+	"-1":                 "👎",
+	"alarm_clock":        "⏰",
+	"bell":               "🔔",
+	"battery":            "🔋",
+	"bulb":               "💡",
+	"calendar":           "📅",
+	"camera":             "📷",
+	"check":              "✔️",
+	"white_check_mark":   "✅",
+	"clock":              "🕒",
+	"cloud":              "☁️",
+	"computer":           "💻",
+	"door":               "🚪",
+	"droplet":            "💧",
+	"email":              "📧",
+	"envelope":           "✉️",
+	"exclamation":        "❗",
+	"fire":               "🔥",
+	"heavy_check_mark":   "✔️",
+	"heart":              "❤️",
+	"house":              "🏠",
+	"hourglass":          "⌛",
+	"information_source": "ℹ️",
+	"key":                "🔑",
+	"lock":               "🔒",
+	"unlock":             "🔓",
+	"loudspeaker":        "📢",
+	"mailbox":            "📫",
+	"no_entry":           "⛔",
+	"package":            "📦",
+	"partying_face":      "🥳",
+	"phone":              "☎️",
+	"question":           "❓",
+	"rain":               "🌧️",
+	"rotating_light":     "🚨",
+	"skull":              "💀",
+	"smile":              "😄",
+	"snowflake":          "❄️",
+	"stop_sign":          "🛑",
+	"sunny":              "☀️",
+	"tada":               "🎉",
+	"thermometer":        "🌡️",
+	"warning":            "⚠️",
+	"washing_machine":    "🧺",
+	"x":                  "❌",
+	"zap":                "⚡",
+}
+
+var shortcodePattern = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// Replace `:shortcode:` sequences with the respective emoji.
+// Unknown shortcodes are left as they are.
+func expandShortcodes(s string) string {
+	return shortcodePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if emoji, ok := emojis[match[1:len(match)-1]]; ok {
+			return emoji
+		}
+		return match
+	})
+}
+
+// Prepend the emoji for all tags which are known shortcodes to the title.
+func prependTags(title string, tags []string) string {
+	prefix := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if emoji, ok := emojis[tag]; ok {
+			prefix = append(prefix, emoji)
+		}
+	}
+	if len(prefix) == 0 {
+		return title
+	}
+	return strings.Join(prefix, "") + " " + title
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error Notifications --------------------------------------------------------
+
+// Minimum time between two error notifications for the same subscription.
+const errorThrottle = 10 * time.Minute
+
+// When the last error notification was shown, per subscription topic.
+var lastErrorNotification = make(map[string]time.Time)
+var errorMutex sync.Mutex
+
+// Show a low-urgency notification about an error in a subscription.
+// Shows at most one notification per subscription within `errorThrottle`.
+func notifyError(s *Subscription, err error) {
+	errorMutex.Lock()
+	last, ok := lastErrorNotification[s.label()]
+	if ok && time.Since(last) < errorThrottle {
+		errorMutex.Unlock()
+		return
+	}
+	lastErrorNotification[s.label()] = time.Now()
+	errorMutex.Unlock()
+
+	_, err = notify(&Notification{
+		Title:   fmt.Sprintf("%v: error in subscription", APPNAME),
+		Body:    fmt.Sprintf("%v\n%v", s.label(), err),
+		Icon:    "dialog-error",
+		Urgency: "low",
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+	}
+}
+
+// Error Summary --------------------------------------------------------------
+
+// Default time window for the error summary.
+const defaultErrorSummaryWindow = time.Hour
+
+// Number of errors in the current summary window.
+var summaryErrors = 0
+var summaryTimer *time.Timer
+var summaryMutex sync.Mutex
+
+// Count an internal error for the error summary.
+// The first error starts a window, at the end of which
+// a single notification tells how many errors occurred.
+func recordError() {
+	config := currentConfig()
+	if !config.ErrorSummary {
+		return
+	}
+
+	summaryMutex.Lock()
+	defer summaryMutex.Unlock()
+	summaryErrors++
+	if summaryTimer == nil {
+		summaryTimer = time.AfterFunc(errorSummaryWindow(), showErrorSummary)
+	}
+}
+
+// The time window for the error summary from config.
+func errorSummaryWindow() time.Duration {
+	config := currentConfig()
+	if config.ErrorSummaryWindow > 0 {
+		return time.Duration(config.ErrorSummaryWindow) * time.Second
+	}
+	return defaultErrorSummaryWindow
+}
+
+// Show a low-urgency notification with the number of errors in the window.
+func showErrorSummary() {
+	summaryMutex.Lock()
+	count := summaryErrors
+	summaryErrors = 0
+	summaryTimer = nil
+	summaryMutex.Unlock()
+
+	if count == 0 {
+		return
+	}
+	window, _ := humanizeDuration(errorSummaryWindow())
+	_, err := notify(&Notification{
+		Title:   fmt.Sprintf("%v: %d errors in the last %v", APPNAME, count, window),
+		Body:    fmt.Sprintf("Run `%v status` for details", APPNAME),
+		Icon:    "dialog-error",
+		Urgency: "low",
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+	}
+}
+
+// Connection Notifications ---------------------------------------------------
+
+// Show a notification with the reason when the connection to the broker
+// is lost, if enabled with `notify_disconnect`.
+func disconnectSink(e Event) {
+	config := currentConfig()
+	if e.Type != eventDisconnected || !config.NotifyDisconnect {
+		return
+	}
+
+	reason := disconnectReason(e.Error)
+	go func() {
+		_, err := notify(&Notification{
+			Title:   fmt.Sprintf("%v: disconnected from %v", APPNAME, config.Host),
+			Body:    reason,
+			Icon:    "network-error",
+			Urgency: "normal",
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to send notification: %v", err)
+		}
+	}()
+}
+
+// A description of why the connection was lost.
+//
+// MQTT 3.1.1 has no reason codes for a disconnect, so the cause is guessed
+// from the error. A broker typically closes the connection when another
+// client connects with the same client ID.
+func disconnectReason(err error) string {
+	if err == nil {
+		return "The connection was closed."
+	}
+
+	var netErr net.Error
+	msg := err.Error()
+	switch {
+	case errors.Is(err, io.EOF), strings.Contains(msg, "connection reset"):
+		return "The broker closed the connection. " +
+			"Is another client connected with the same client ID?"
+	case strings.Contains(msg, "pingresp not received"):
+		return "The broker did not respond to keepalive messages."
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "The connection to the broker timed out."
+	}
+	return msg
+}
@@ -0,0 +1,56 @@
+package main
+
+// Events ---------------------------------------------------------------------
+//
+// Things that happen to messages and notifications are published as events.
+// Optional features like feedback topics or hooks consume these events
+// instead of being called directly from the message handling.
+
+// Event types.
+const (
+	eventReceived        = "received"   // a message for a subscription
+	eventShown           = "shown"      // a notification was sent
+	eventSuppressed      = "suppressed" // a message produced no notification
+	eventAction          = "action"     // the user invoked an action
+	eventClosed          = "closed"     // a notification was closed
+	eventNotifyFailed    = "notify_failed"
+	eventConnected       = "connected" // to the MQTT broker
+	eventDisconnected    = "disconnected"
+	eventSubscribeFailed = "subscribe_failed"
+	eventReloaded        = "reloaded" // the configuration
+)
+
+// Something that happened. Fields which do not apply to the type are empty.
+type Event struct {
+	Type         string
+	Topic        string
+	Subscription *Subscription
+	ID           uint32 // notification ID
+	Server       string // unique bus name of the server which shows it
+	Action       string // action key, for "action"
+	Reason       uint32 // close reason, for "closed"
+	Error        error
+}
+
+// Consumers of events, called in order for each event.
+// Handlers must not block.
+var eventSinks []func(Event)
+
+// Set in init, because some sinks lead back to `emit`,
+// which would be an initialization cycle otherwise.
+func init() {
+	eventSinks = []func(Event){
+		statsSink,
+		feedbackSink,
+		hookSink,
+		disconnectSink,
+		internalSink,
+	}
+}
+
+// Publish an event to all sinks.
+func emit(e Event) {
+	for _, sink := range eventSinks {
+		sink(e)
+	}
+}
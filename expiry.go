@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message Age ----------------------------------------------------------------
+//
+// MQTT 5 has a message expiry interval, but the client speaks MQTT 3.1.1,
+// so the age of a message can only be told from a timestamp in its payload.
+// With `max_age`, messages whose timestamp is older are dropped,
+// or marked as stale with `"on_expired": "mark"`.
+// Messages without a timestamp are never expired.
+
+// Fields which hold the creation time of a message, in order of priority.
+var messageTimeFields = []string{"timestamp", "time", "ts"}
+
+// The time a message was created, from a top-level `timestamp`, `time`
+// or `ts` field in a JSON payload, the first one which is present.
+// Field names are matched case-insensitively if there is no exact match.
+func messageTime(ctx *TemplateContext) (time.Time, bool) {
+	if ctx.contentType != contentTypeJSON {
+		return time.Time{}, false
+	}
+	data, err := ctx.JSON()
+	if err != nil {
+		return time.Time{}, false
+	}
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, name := range messageTimeFields {
+		value, ok := fields[name]
+		for _, key := range keys {
+			if ok {
+				break
+			}
+			if strings.EqualFold(key, name) {
+				value, ok = fields[key]
+			}
+		}
+		if !ok {
+			continue
+		}
+		if n, ok := value.(float64); ok && n > 1e12 {
+			value = n / 1000 // milliseconds
+		}
+		t, err := toTime(value)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// Whether the message is older than `max_age` when it is handled.
+func (s *Subscription) expired(ctx *TemplateContext) bool {
+	if s.MaxAge <= 0 {
+		return false
+	}
+	created, ok := messageTime(ctx)
+	if !ok {
+		return false
+	}
+	return ctx.received.Sub(created) > time.Duration(s.MaxAge)*time.Second
+}
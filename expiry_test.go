@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageTime(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name        string
+		payload     string
+		contentType string
+		timestamps  bool // with `parse_timestamps`
+		expected    time.Time
+		ok          bool
+	}{
+		{"seconds", `{"time": 1709294400}`, contentTypeJSON, false, base, true},
+		{"milliseconds", `{"ts": 1709294400000}`, contentTypeJSON, false, base, true},
+		{"rfc 3339", `{"timestamp": "2024-03-01T12:00:00Z"}`, contentTypeJSON, false, base, true},
+		{"parsed", `{"time": 1709294400}`, contentTypeJSON, true, base, true},
+		{"priority", `{"ts": 1, "time": 2, "timestamp": 1709294400}`, contentTypeJSON, false, base, true},
+		{"priority without timestamp", `{"ts": 1, "time": 1709294400}`, contentTypeJSON, false, base, true},
+		{"case", `{"Time": 1709294400}`, contentTypeJSON, false, base, true},
+		{"exact before case", `{"TIME": 1, "time": 1709294400}`, contentTypeJSON, false, base, true},
+		{"invalid", `{"time": "yesterday", "ts": 1709294400}`, contentTypeJSON, false, time.Time{}, false},
+		{"nested", `{"data": {"time": 1709294400}}`, contentTypeJSON, false, time.Time{}, false},
+		{"missing", `{"value": 1}`, contentTypeJSON, false, time.Time{}, false},
+		{"not an object", `[1709294400]`, contentTypeJSON, false, time.Time{}, false},
+		{"not json", `time: 1709294400`, contentTypeText, false, time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		ctx := NewTemplateContext("a/b", c.payload, c.contentType)
+		ctx.timestamps = c.timestamps
+		actual, ok := messageTime(&ctx)
+		if ok != c.ok || !actual.Equal(c.expected) {
+			t.Errorf("%v: expected %v %v, got %v %v", c.name, c.expected, c.ok, actual, ok)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// Feedback -------------------------------------------------------------------
+
+// Where to report actions for a notification.
+type feedback struct {
+	FeedbackTopic string
+	Topic         string
+}
+
+// The message published to the feedback topic.
+type FeedbackMessage struct {
+	ID     uint32 `json:"id"`
+	Topic  string `json:"topic"`
+	Event  string `json:"event"`
+	Action string `json:"action,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Reasons for the NotificationClosed signal, from the notification spec.
+var closeReasons = map[uint32]string{
+	1: "expired",
+	2: "dismissed",
+	3: "closed",
+	4: "undefined",
+}
+
+// Feedback targets by notification.
+var feedbacks = make(map[notificationRef]feedback)
+var feedbackMutex sync.Mutex
+
+// Remember that actions for the given notification
+// should be published to `feedbackTopic`.
+func addFeedback(id notificationRef, feedbackTopic, topic string) {
+	feedbackMutex.Lock()
+	defer feedbackMutex.Unlock()
+	feedbacks[id] = feedback{FeedbackTopic: feedbackTopic, Topic: topic}
+}
+
+// Forget the feedback target for a notification which was closed.
+func forgetFeedback(id notificationRef) {
+	feedbackMutex.Lock()
+	defer feedbackMutex.Unlock()
+	delete(feedbacks, id)
+}
+
+// Publish events for subscriptions with a `feedback_topic`.
+func feedbackSink(e Event) {
+	switch e.Type {
+	case eventShown:
+		if e.Subscription.FeedbackTopic == "" {
+			return
+		}
+		addFeedback(notificationRef{e.Server, e.ID}, e.Subscription.FeedbackTopic, e.Topic)
+		publishEvent(e.Subscription.FeedbackTopic, FeedbackMessage{
+			ID:    e.ID,
+			Topic: e.Topic,
+			Event: eventShown,
+		})
+	case eventSuppressed:
+		if e.Subscription.FeedbackTopic == "" {
+			return
+		}
+		publishEvent(e.Subscription.FeedbackTopic, FeedbackMessage{
+			Topic: e.Topic,
+			Event: eventSuppressed,
+		})
+	case eventAction, eventClosed:
+		feedbackMutex.Lock()
+		fb, ok := feedbacks[notificationRef{e.Server, e.ID}]
+		feedbackMutex.Unlock()
+		if !ok {
+			return
+		}
+		msg := FeedbackMessage{
+			ID:     e.ID,
+			Topic:  fb.Topic,
+			Event:  e.Type,
+			Action: e.Action,
+		}
+		if e.Type == eventClosed {
+			msg.Reason = closeReasons[e.Reason]
+			forgetFeedback(notificationRef{e.Server, e.ID})
+		}
+		publishEvent(fb.FeedbackTopic, msg)
+	}
+}
+
+// Publish a feedback message in the background.
+func publishEvent(feedbackTopic string, msg FeedbackMessage) {
+	if mqttClient == nil {
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode feedback: %v", err)
+		return
+	}
+
+	t := mqttClient.Publish(feedbackTopic, 0, false, payload)
+	go func() {
+		t.Wait()
+		if t.Error() != nil {
+			log.Printf("ERROR: Failed to publish feedback: %v", t.Error())
+		}
+	}()
+}
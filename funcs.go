@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template Functions ---------------------------------------------------------
+
+// Functions which are available in title and body templates.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"now":              time.Now,
+		"number":           formatNumber,
+		"clock":            formatClock,
+		"date":             formatDate,
+		"weekday":          formatWeekday,
+		"humanizeDuration": humanizeDuration,
+		"timeAgo":          timeAgo,
+		"lookup":           lookup,
+		"csv":              splitFields,
+		"retained":         retained,
+	}
+}
+
+// Convert a template value to a float.
+// Accepts numbers, strings and anything with a String() method,
+// e.g. the template context itself.
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case fmt.Stringer:
+		return strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+	}
+	return 0, fmt.Errorf("Cannot convert %T to a number", value)
+}
+
+// Convert a template value to a time.
+// Accepts time values, Unix timestamps (seconds) and RFC 3339 strings.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	seconds, err := toFloat(value)
+	if err != nil {
+		return time.Time{}, errors.New("Cannot convert value to a time")
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// Format a number with the given number of decimals,
+// using the separators from the numeric locale.
+func formatNumber(decimals int, value interface{}) (string, error) {
+	f, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+	loc := localeFor("LC_NUMERIC")
+
+	raw := strconv.FormatFloat(f, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(raw, "-") {
+		sign = "-"
+		raw = raw[1:]
+	}
+	parts := strings.SplitN(raw, ".", 2)
+
+	// group the integer part in threes
+	integer := parts[0]
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(loc.Thousands)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	result := sign + grouped.String()
+	if len(parts) > 1 {
+		result += loc.Decimal + parts[1]
+	}
+	return result, nil
+}
+
+// Format the time of day as 12 or 24 hour clock, depending on the time locale.
+func formatClock(value interface{}) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+	if localeFor("LC_TIME").Clock24 {
+		return t.Local().Format("15:04"), nil
+	}
+	return t.Local().Format("3:04 PM"), nil
+}
+
+// Format the date as usual for the time locale.
+func formatDate(value interface{}) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+	return t.Local().Format(localeFor("LC_TIME").DateFormat), nil
+}
+
+// Name of the weekday in the language of the time locale.
+func formatWeekday(value interface{}) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+	return localeFor("LC_TIME").Weekdays[t.Local().Weekday()], nil
+}
+
+// Format a duration like "4d 2h", with the two largest units.
+// Accepts durations, seconds and duration strings like "90m".
+func humanizeDuration(value interface{}) (string, error) {
+	d, err := toDuration(value)
+	if err != nil {
+		return "", err
+	}
+	if d < 0 {
+		d = -d
+	}
+
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+	for i, unit := range units {
+		n := d / unit.size
+		if n == 0 && i < len(units)-1 {
+			continue
+		}
+		result := fmt.Sprintf("%d%v", n, unit.suffix)
+		if i < len(units)-1 {
+			next := units[i+1]
+			if rest := (d - n*unit.size) / next.size; rest > 0 {
+				result += fmt.Sprintf(" %d%v", rest, next.suffix)
+			}
+		}
+		return result, nil
+	}
+	return "", nil
+}
+
+// Describe a time relative to now, like "3 minutes ago" or "in 2 hours".
+func timeAgo(value interface{}) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+
+	d := time.Since(t)
+	format := "%v ago"
+	if d < 0 {
+		d = -d
+		format = "in %v"
+	}
+
+	var amount string
+	switch {
+	case d < time.Minute:
+		return "just now", nil
+	case d < time.Hour:
+		amount = plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		amount = plural(int(d/time.Hour), "hour")
+	default:
+		amount = plural(int(d/(24*time.Hour)), "day")
+	}
+	return fmt.Sprintf(format, amount), nil
+}
+
+// Format a count with a singular or plural noun.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %v", noun)
+	}
+	return fmt.Sprintf("%d %vs", n, noun)
+}
+
+// Convert a template value to a duration.
+// Accepts durations, duration strings and numbers (seconds).
+func toDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err == nil {
+			return d, nil
+		}
+	}
+
+	seconds, err := toFloat(value)
+	if err != nil {
+		return 0, errors.New("Cannot convert value to a duration")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Look up the friendly name for `key` in the map `name` from config.
+// Returns the key itself if there is no entry for it.
+func lookup(name string, key interface{}) (string, error) {
+	config := currentConfig()
+	m, ok := config.Maps[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown map %q", name)
+	}
+	k := fmt.Sprint(key)
+	if value, ok := m[k]; ok {
+		return value, nil
+	}
+	return k, nil
+}
+
+// Split a delimiter-separated value into fields.
+// Whitespace around each field is removed.
+func splitFields(sep string, value interface{}) []string {
+	fields := strings.Split(strings.TrimSpace(fmt.Sprint(value)), sep)
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+	return fields
+}
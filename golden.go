@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// Test Command ---------------------------------------------------------------
+//
+// `mqtt-dbus-notify test DIR` runs the messages from the case files in DIR
+// through the configured subscriptions and compares the notifications
+// with the expected results. No notifications are shown.
+
+// A test case, read from a JSON file.
+type testCase struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+
+	// expected results, missing values are not checked
+	Title    *string `json:"title"`
+	Body     *string `json:"body"`
+	Urgency  *string `json:"urgency"`
+	Filtered bool    `json:"filtered"` // expect no notification
+}
+
+// Run all test cases from the given directory and print the results.
+// Returns an error if any case fails.
+func runTests(dir string) error {
+	if dir == "" {
+		return errors.New("Usage: mqtt-dbus-notify test DIR")
+	}
+
+	err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	failed := 0
+	for _, path := range paths {
+		name := filepath.Base(path)
+		problems, err := runTest(path)
+		if err != nil {
+			problems = []string{err.Error()}
+		}
+
+		if len(problems) == 0 {
+			fmt.Printf("PASS %v\n", name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %v\n", name)
+		for _, problem := range problems {
+			fmt.Printf("     %v\n", problem)
+		}
+	}
+
+	fmt.Printf("\n%d of %d cases passed\n", len(paths)-failed, len(paths))
+	if failed > 0 {
+		return fmt.Errorf("%d cases failed", failed)
+	}
+	return nil
+}
+
+// Run a single test case and return a list of differences.
+func runTest(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tc testCase
+	err = json.Unmarshal(data, &tc)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := renderFirst(tc.Topic, tc.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]string, 0)
+	if n == nil {
+		if !tc.Filtered {
+			problems = append(problems, "expected a notification, got none")
+		}
+		return problems, nil
+	}
+	if tc.Filtered {
+		problems = append(problems, "expected no notification")
+	}
+
+	compare := func(field string, expected *string, actual string) {
+		if expected != nil && *expected != actual {
+			problems = append(problems, fmt.Sprintf(
+				"%v: expected %q, got %q", field, *expected, actual))
+		}
+	}
+	compare("title", tc.Title, n.Title)
+	compare("body", tc.Body, n.Body)
+	compare("urgency", tc.Urgency, n.Urgency)
+	return problems, nil
+}
+
+// Render the first notification for a message,
+// like `dispatch` would, but without showing it.
+// Returns nil if no subscription produces a notification.
+func renderFirst(topic, payload string) (*Notification, error) {
+	for _, sub := range currentConfig().matchingSubscriptions(topic) {
+		ctx, ok := sub.accept(topic, payload)
+		if ok {
+			n, _, err := sub.render(ctx)
+			return n, err
+		}
+		if sub.Stop {
+			break
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Startup Grace --------------------------------------------------------------
+//
+// After every (re)connect, the broker sends the retained messages
+// for all subscribed topics again. With `startup_grace`, retained messages
+// which arrive within that many seconds after connecting are "stale"
+// and handled according to the `stale_policy`.
+
+const stalePolicyMark = "mark"
+const stalePolicyDrop = "drop"
+const stalePolicySummary = "summary"
+
+var connectedAt time.Time
+var staleCount = 0
+var graceMutex sync.Mutex
+
+// Start the grace period after connecting to the broker.
+func startGrace() {
+	config := currentConfig()
+	if config.StartupGrace <= 0 {
+		return
+	}
+
+	graceMutex.Lock()
+	connectedAt = time.Now()
+	graceMutex.Unlock()
+
+	if config.StalePolicy == stalePolicySummary {
+		time.AfterFunc(startupGrace(), showStaleSummary)
+	}
+}
+
+func startupGrace() time.Duration {
+	config := currentConfig()
+	return time.Duration(config.StartupGrace) * time.Second
+}
+
+// Whether a message with the given retained flag, received now, is stale.
+func isStale(retained bool) bool {
+	config := currentConfig()
+	if !retained || config.StartupGrace <= 0 {
+		return false
+	}
+
+	graceMutex.Lock()
+	defer graceMutex.Unlock()
+	return time.Since(connectedAt) < startupGrace()
+}
+
+// Drop a stale message, unless the `stale_policy` is to mark it.
+// Returns true if the message was dropped.
+func dropStale() bool {
+	config := currentConfig()
+	switch config.StalePolicy {
+	case stalePolicyDrop:
+		return true
+	case stalePolicySummary:
+		graceMutex.Lock()
+		staleCount++
+		graceMutex.Unlock()
+		return true
+	}
+	return false
+}
+
+// Show a single notification for the stale messages after the grace period.
+func showStaleSummary() {
+	config := currentConfig()
+	graceMutex.Lock()
+	count := staleCount
+	staleCount = 0
+	graceMutex.Unlock()
+
+	if count == 0 {
+		return
+	}
+	_, err := notify(&Notification{
+		Title: APPNAME,
+		Body:  fmt.Sprintf("%d retained messages after connecting", count),
+		Icon:  config.Icon,
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+// Stale messages that only feed `retained_topics`
+// do not count towards the stale summary.
+func TestStaleSummaryCount(t *testing.T) {
+	previous := currentConfig()
+	setConfig(&Config{
+		RetainedTopics: []string{"home/mode"},
+		StalePolicy:    stalePolicySummary,
+		Subscriptions:  []*Subscription{{Topic: "home/door"}},
+	})
+	defer setConfig(previous)
+
+	availableMutex.Lock()
+	wasAvailable := serviceAvailable
+	serviceAvailable = true
+	availableMutex.Unlock()
+	defer func() {
+		availableMutex.Lock()
+		serviceAvailable = wasAvailable
+		availableMutex.Unlock()
+	}()
+
+	graceMutex.Lock()
+	staleCount = 0
+	graceMutex.Unlock()
+
+	dispatch(queuedMessage{Topic: "home/mode", Payload: "away", Stale: true})
+	dispatch(queuedMessage{Topic: "home/door", Payload: "open", Stale: true})
+
+	graceMutex.Lock()
+	count := staleCount
+	staleCount = 0
+	graceMutex.Unlock()
+	if count != 1 {
+		t.Errorf("Expected 1 stale message, got %d", count)
+	}
+	if value, _ := retained("home/mode"); value != "away" {
+		t.Errorf("Expected retained value %q, got %q", "away", value)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"text/template"
+)
+
+// Hooks ----------------------------------------------------------------------
+//
+// Hooks run a command or publish an MQTT message on lifecycle events,
+// e.g. to integrate with monitoring scripts.
+
+// Events which can have hooks.
+var hookEvents = []string{eventConnected, eventDisconnected,
+	eventSubscribeFailed, eventNotifyFailed}
+
+// An action for a lifecycle event.
+// Either runs `command` or publishes `payload` to the `publish` topic.
+type Hook struct {
+	Command []string `json:"command"`
+	Publish string   `json:"publish"`
+	Payload string   `json:"payload"`
+	Retain  bool     `json:"retain"`
+}
+
+// Details about an event, available in the payload template.
+type HookEvent struct {
+	Event string
+	Topic string
+	Error string
+}
+
+// Run all hooks for an event in the background.
+func hookSink(event Event) {
+	config := currentConfig()
+	hooks := config.Hooks[event.Type]
+	if len(hooks) == 0 {
+		return
+	}
+
+	e := HookEvent{Event: event.Type, Topic: event.Topic}
+	if event.Error != nil {
+		e.Error = event.Error.Error()
+	}
+	for _, hook := range hooks {
+		go func(hook *Hook) {
+			err := hook.run(e)
+			if err != nil {
+				log.Printf("ERROR: Hook for %v failed: %v", e.Event, err)
+			}
+		}(hook)
+	}
+}
+
+// Run the hook for an event.
+func (h *Hook) run(e HookEvent) error {
+	if len(h.Command) > 0 {
+		cmd := exec.Command(h.Command[0], h.Command[1:]...)
+		cmd.Env = append(os.Environ(),
+			"MQTT_NOTIFY_EVENT="+e.Event,
+			"MQTT_NOTIFY_TOPIC="+e.Topic,
+			"MQTT_NOTIFY_ERROR="+e.Error)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+		}
+	}
+
+	if h.Publish != "" {
+		if mqttClient == nil || !mqttClient.IsConnected() {
+			return fmt.Errorf("Cannot publish to %v, not connected", h.Publish)
+		}
+		tpl, err := template.New("hook").Parse(h.Payload)
+		if err != nil {
+			return err
+		}
+		var payload bytes.Buffer
+		err = tpl.Execute(&payload, e)
+		if err != nil {
+			return err
+		}
+		t := mqttClient.Publish(h.Publish, 0, h.Retain, payload.Bytes())
+		t.Wait()
+		return t.Error()
+	}
+	return nil
+}
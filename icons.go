@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Icon Themes ----------------------------------------------------------------
+//
+// Icon names are resolved against the installed icon themes, see
+// https://specifications.freedesktop.org/icon-theme-spec/latest/
+// This is only used to warn about missing icons,
+// the notification server does the actual lookup.
+//
+// Walking the theme directories is slow, so the icons are indexed once
+// per theme, when an icon is first checked, and the index is kept
+// for reloads of the configuration.
+
+var iconExtensions = []string{".png", ".svg", ".xpm"}
+
+// Names of the available icons, by theme ("" for all themes).
+var iconIndex = make(map[string]map[string]bool)
+var iconIndexMutex sync.Mutex
+
+// Base directories for icon themes, in lookup order.
+func iconDirs() []string {
+	var dirs []string
+	if currentUser, err := user.Current(); err == nil {
+		dirs = append(dirs, filepath.Join(currentUser.HomeDir, ".icons"))
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		if currentUser, err := user.Current(); err == nil {
+			dataHome = filepath.Join(currentUser.HomeDir, ".local", "share")
+		}
+	}
+	if dataHome != "" {
+		dirs = append(dirs, filepath.Join(dataHome, "icons"))
+	}
+
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+	for _, dir := range filepath.SplitList(dataDirs) {
+		dirs = append(dirs, filepath.Join(dir, "icons"))
+	}
+	return append(dirs, "/usr/share/pixmaps")
+}
+
+// The themes to search: the given theme, the themes it inherits from
+// and "hicolor". Returns nil to search all themes.
+func iconThemes(theme string, dirs []string) []string {
+	if theme == "" {
+		return nil
+	}
+
+	var themes []string
+	seen := make(map[string]bool)
+	queue := []string{theme}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		themes = append(themes, name)
+		queue = append(queue, themeParents(name, dirs)...)
+	}
+	if !seen["hicolor"] {
+		themes = append(themes, "hicolor")
+	}
+	return themes
+}
+
+// Read the `Inherits` key from the index.theme file of a theme.
+func themeParents(theme string, dirs []string) []string {
+	for _, dir := range dirs {
+		f, err := os.Open(filepath.Join(dir, theme, "index.theme"))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "Inherits=") {
+				return strings.Split(strings.TrimPrefix(line, "Inherits="), ",")
+			}
+		}
+	}
+	return nil
+}
+
+// The names of all available icons, from the index.
+func availableIcons(theme string) map[string]bool {
+	iconIndexMutex.Lock()
+	defer iconIndexMutex.Unlock()
+	icons, ok := iconIndex[theme]
+	if !ok {
+		icons = indexIcons(theme)
+		iconIndex[theme] = icons
+	}
+	return icons
+}
+
+// Collect the names of all available icons.
+func indexIcons(theme string) map[string]bool {
+	dirs := iconDirs()
+	themes := iconThemes(theme, dirs)
+
+	icons := make(map[string]bool)
+	collect := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // skip unreadable directories
+		}
+		ext := filepath.Ext(path)
+		for _, known := range iconExtensions {
+			if ext == known {
+				icons[strings.TrimSuffix(info.Name(), ext)] = true
+			}
+		}
+		return nil
+	}
+
+	for _, dir := range dirs {
+		if themes == nil || filepath.Base(dir) == "pixmaps" {
+			filepath.Walk(dir, collect)
+			continue
+		}
+		for _, name := range themes {
+			filepath.Walk(filepath.Join(dir, name), collect)
+		}
+	}
+	return icons
+}
+
+// Check that the configured icons exist and warn about missing ones.
+// Missing icons are replaced with the `fallback_icon`, if one is configured.
+func (c *Config) checkIcons() {
+	var available map[string]bool
+	resolve := func(icon *string, where string) {
+		// paths, URLs and empty values are not theme icons
+		if *icon == "" || strings.ContainsAny(*icon, "/:") {
+			return
+		}
+		if available == nil {
+			available = availableIcons(c.IconTheme)
+		}
+		if available[*icon] {
+			return
+		}
+
+		log.Printf("WARNING: Icon %q for %v not found in icon theme", *icon, where)
+		if c.FallbackIcon != "" {
+			*icon = c.FallbackIcon
+		}
+	}
+
+	resolve(&c.Icon, "default icon")
+	for _, sub := range c.Subscriptions {
+		resolve(&sub.Icon, sub.label())
+	}
+}
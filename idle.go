@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Idle Sessions --------------------------------------------------------------
+//
+// Subscriptions with `idle_unsubscribe` are unsubscribed while the session
+// is idle or locked for longer than `idle_timeout`, to save bandwidth
+// for busy topics nobody looks at. Idle state comes from logind.
+
+const (
+	logindName    = "org.freedesktop.login1"
+	logindSession = dbus.ObjectPath("/org/freedesktop/login1/session/auto")
+)
+
+// Default for `idle_timeout`, in seconds.
+const defaultIdleTimeout = 600
+
+// How often the idle state is checked.
+const idleCheckInterval = 30 * time.Second
+
+var sessionIdle bool
+var lockedSince time.Time
+var idleMutex sync.Mutex
+
+// Closed to stop the running idle monitor, nil if there is none.
+var idleStop chan struct{}
+
+// Whether the session is considered idle.
+func isIdle() bool {
+	idleMutex.Lock()
+	defer idleMutex.Unlock()
+	return sessionIdle
+}
+
+// Check the idle state periodically, if any subscription uses it.
+// Called again after a reload, which stops the previous monitor.
+func startIdleMonitor() {
+	config := currentConfig()
+	used := false
+	for _, sub := range config.Subscriptions {
+		used = used || sub.IdleUnsubscribe
+	}
+
+	idleMutex.Lock()
+	defer idleMutex.Unlock()
+	if idleStop != nil {
+		close(idleStop)
+		idleStop = nil
+	}
+	if !used || *systemMode || mqttClient == nil {
+		// not idle as far as the subscriptions are concerned
+		sessionIdle = false
+		lockedSince = time.Time{}
+		return
+	}
+
+	stop := make(chan struct{})
+	idleStop = stop
+	go func() {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				checkIdle()
+			}
+		}
+	}()
+}
+
+// Update the idle state and (un)subscribe if it changed.
+func checkIdle() {
+	idle, err := queryIdle()
+	if err != nil {
+		log.Printf("WARNING: Cannot get idle state from logind: %v", err)
+		return
+	}
+
+	idleMutex.Lock()
+	changed := idle != sessionIdle
+	sessionIdle = idle
+	idleMutex.Unlock()
+
+	if changed {
+		log.Printf("Session idle: %v", idle)
+		resubscribe()
+	}
+}
+
+// Ask logind whether the session is idle or locked for longer than
+// the `idle_timeout`.
+func queryIdle() (bool, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	session := conn.Object(logindName, logindSession)
+
+	v, err := session.GetProperty(logindName + ".Session.LockedHint")
+	if err != nil {
+		return false, err
+	}
+	locked, _ := v.Value().(bool)
+
+	v, err = session.GetProperty(logindName + ".Session.IdleHint")
+	if err != nil {
+		return false, err
+	}
+	idle, _ := v.Value().(bool)
+
+	v, err = session.GetProperty(logindName + ".Session.IdleSinceHint")
+	if err != nil {
+		return false, err
+	}
+	usec, _ := v.Value().(uint64)
+	idleSince := time.Unix(0, int64(usec)*int64(time.Microsecond))
+
+	// logind does not tell since when the session is locked
+	idleMutex.Lock()
+	if !locked {
+		lockedSince = time.Time{}
+	} else if lockedSince.IsZero() {
+		lockedSince = time.Now()
+	}
+	since := lockedSince
+	idleMutex.Unlock()
+
+	if idle && (since.IsZero() || idleSince.Before(since)) {
+		since = idleSince
+	}
+	return !since.IsZero() && time.Since(since) >= idleTimeout(), nil
+}
+
+func idleTimeout() time.Duration {
+	config := currentConfig()
+	if config.IdleTimeout > 0 {
+		return time.Duration(config.IdleTimeout) * time.Second
+	}
+	return defaultIdleTimeout * time.Second
+}
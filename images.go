@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Image Cache ----------------------------------------------------------------
+//
+// Images are written to files below $XDG_CACHE_HOME so that they can be used
+// as icons. The file name is derived from the content (or URL),
+// so the same image is stored once.
+
+// Limits for the image cache.
+type ImageCacheConfig struct {
+	MaxSize      int `json:"max_size"`      // megabytes
+	TTL          int `json:"ttl"`           // seconds
+	MaxDownloads int `json:"max_downloads"` // concurrent downloads
+}
+
+const (
+	defaultCacheSize    = 50
+	defaultCacheTTL     = 24 * 60 * 60
+	defaultMaxDownloads = 2
+	downloadTimeout     = 10 * time.Second
+)
+
+var imageClient = &http.Client{Timeout: downloadTimeout}
+
+// Limits the number of concurrent downloads.
+var downloadSlots chan struct{}
+var downloadSlotsOnce sync.Once
+
+// Serializes writes and cleanup of the cache directory.
+var imageCacheMutex sync.Mutex
+
+// The cache limits with defaults for unset values.
+func imageCacheLimits() (maxSize int64, ttl time.Duration, maxDownloads int) {
+	config := currentConfig()
+	c := config.ImageCache
+	if c == nil {
+		c = &ImageCacheConfig{}
+	}
+	maxSize = defaultCacheSize
+	if c.MaxSize > 0 {
+		maxSize = int64(c.MaxSize)
+	}
+	ttl = defaultCacheTTL
+	if c.TTL > 0 {
+		ttl = time.Duration(c.TTL)
+	}
+	maxDownloads = defaultMaxDownloads
+	if c.MaxDownloads > 0 {
+		maxDownloads = c.MaxDownloads
+	}
+	return maxSize << 20, ttl * time.Second, maxDownloads
+}
+
+// The directory for cached images.
+func imageCacheDir() (string, error) {
+	return cacheDir("images")
+}
+
+// A directory below the user's cache directory ($XDG_CACHE_HOME).
+func cacheDir(name string) (string, error) {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(currentUser.HomeDir, ".cache")
+	}
+	dir := filepath.Join(base, APPNAME, name)
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// Write an image payload to the cache so that it can be used as icon.
+func saveImage(payload, contentType string) (string, error) {
+	sum := sha1.Sum([]byte(payload))
+	return storeImage(hex.EncodeToString(sum[:]), contentType, []byte(payload))
+}
+
+// Store image data under the given key. Returns the path to the file.
+func storeImage(key, contentType string, data []byte) (string, error) {
+	maxSize, ttl, _ := imageCacheLimits()
+	if int64(len(data)) > maxSize {
+		return "", fmt.Errorf("Image of %d bytes exceeds the cache size", len(data))
+	}
+
+	dir, err := imageCacheDir()
+	if err != nil {
+		return "", err
+	}
+	ext := ""
+	exts, _ := mime.ExtensionsByType(contentType)
+	if len(exts) > 0 {
+		ext = exts[0]
+	}
+	path := filepath.Join(dir, key+ext)
+
+	imageCacheMutex.Lock()
+	defer imageCacheMutex.Unlock()
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < ttl {
+		return path, nil
+	}
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		return "", err
+	}
+	pruneImages(dir, maxSize, ttl, path)
+	return path, nil
+}
+
+// Write data to a temporary file next to `path` and rename it,
+// so that a file which is in use is replaced, not partially overwritten.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Find a cached image for the given key which is not older than the TTL.
+func cachedImage(key string) (string, bool) {
+	_, ttl, _ := imageCacheLimits()
+	dir, err := imageCacheDir()
+	if err != nil {
+		return "", false
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, key+"*"))
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) < ttl {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Remove expired images and the oldest images until the cache fits into
+// `maxSize`. The file at `keep` is never removed.
+func pruneImages(dir string, maxSize int64, ttl time.Duration, keep string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		if path == keep {
+			continue
+		}
+		if time.Since(f.ModTime()) < ttl && total <= maxSize {
+			continue
+		}
+		err := os.Remove(path)
+		if err != nil {
+			log.Printf("WARNING: Failed to remove cached image: %v", err)
+			continue
+		}
+		total -= f.Size()
+	}
+}
+
+// Load an image from a URL, a data URI or base64 encoded data.
+// Returns the path to the cached file.
+func loadImage(ref string) (string, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return downloadImage(ref)
+	}
+
+	encoded := ref
+	if strings.HasPrefix(ref, "data:") {
+		parts := strings.SplitN(ref, ",", 2)
+		if len(parts) != 2 || !strings.HasSuffix(parts[0], ";base64") {
+			return "", fmt.Errorf("Unsupported data URI")
+		}
+		encoded = parts[1]
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	contentType := http.DetectContentType(data)
+	if !isImage(contentType) {
+		return "", fmt.Errorf("Not an image: %v", contentType)
+	}
+	return saveImage(string(data), contentType)
+}
+
+// Download an image, unless it is already cached.
+func downloadImage(url string) (string, error) {
+	sum := sha1.Sum([]byte(url))
+	key := "url-" + hex.EncodeToString(sum[:])
+	if path, ok := cachedImage(key); ok {
+		return path, nil
+	}
+
+	maxSize, _, maxDownloads := imageCacheLimits()
+	downloadSlotsOnce.Do(func() {
+		downloadSlots = make(chan struct{}, maxDownloads)
+	})
+	downloadSlots <- struct{}{}
+	defer func() { <-downloadSlots }()
+
+	// may have been downloaded while waiting
+	if path, ok := cachedImage(key); ok {
+		return path, nil
+	}
+
+	resp, err := imageClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("Unexpected status %v", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	contentType := http.DetectContentType(data)
+	if !isImage(contentType) {
+		return "", fmt.Errorf("Not an image: %v", contentType)
+	}
+	return storeImage(key, contentType, data)
+}
+
+// Load the image for a message from the `image` template.
+// The template yields a URL, data URI or base64 encoded image.
+func (s *Subscription) image(ctx *TemplateContext) (string, error) {
+	ref, err := s.templates.render("image", s.Image, ctx)
+	if err != nil {
+		return "", err
+	}
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return s.Icon, nil
+	}
+	return loadImage(ref)
+}
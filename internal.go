@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+)
+
+// Internal Topics ------------------------------------------------------------
+//
+// The program's own events are published as messages on pseudo-topics
+// below `$internal/`, e.g. `$internal/connected`. They go through the same
+// pipeline as MQTT messages, so subscriptions can show, style or filter them.
+// Internal topics are never subscribed at the broker.
+
+const internalPrefix = "$internal/"
+
+// Events which are published on internal topics.
+var internalEvents = map[string]bool{
+	eventConnected:       true,
+	eventDisconnected:    true,
+	eventSubscribeFailed: true,
+	eventNotifyFailed:    true,
+	eventReloaded:        true,
+}
+
+// The payload of an internal message, as JSON.
+type InternalMessage struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+	Topic   string `json:"topic,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Version string `json:"version"` // of this program
+	Server  string `json:"server"`  // name and version of the notifications server
+}
+
+// Whether the topic is an internal pseudo-topic.
+func isInternal(topic string) bool {
+	return strings.HasPrefix(topic, internalPrefix)
+}
+
+// Publish an event on its internal topic,
+// if a subscription is interested.
+func internalSink(e Event) {
+	if !internalEvents[e.Type] || isInternal(e.Topic) {
+		// no internal messages about internal messages
+		return
+	}
+	topic := internalPrefix + e.Type
+	if len(currentConfig().matchingSubscriptions(topic)) == 0 {
+		return
+	}
+
+	m := InternalMessage{
+		Event:   e.Type,
+		Message: internalText(e),
+		Topic:   e.Topic,
+		Version: appVersion(),
+		Server:  serverStatus().server,
+	}
+	if e.Error != nil {
+		m.Error = e.Error.Error()
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode internal message: %v", err)
+		return
+	}
+	// the sink must not block
+	go enqueue(queuedMessage{Topic: topic, Payload: string(payload)})
+}
+
+// A short description of an event.
+func internalText(e Event) string {
+	config := currentConfig()
+	switch e.Type {
+	case eventConnected:
+		return fmt.Sprintf("Connected to %v", config.Host)
+	case eventDisconnected:
+		return fmt.Sprintf("Disconnected from %v", config.Host)
+	case eventSubscribeFailed:
+		return fmt.Sprintf("Failed to subscribe to %v", e.Topic)
+	case eventNotifyFailed:
+		return "Failed to show a notification"
+	case eventReloaded:
+		return "Configuration reloaded"
+	}
+	return e.Type
+}
+
+// The version of this program, from the build info.
+func appVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return info.Main.Version
+}
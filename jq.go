@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// jq Expressions -------------------------------------------------------------
+//
+// With `"engine": "jq"`, a subscription uses a jq expression instead of
+// templates. The expression gets the JSON payload as input and `$topic`,
+// and produces an object with `title`, `body`, `icon` and `urgency`.
+// Expressions are compiled once when the configuration is loaded
+// and run in-process with gojq, no `jq` program is needed.
+
+const engineTemplate = "template"
+const engineJQ = "jq"
+
+// Maximum time for a jq expression.
+const jqTimeout = 2 * time.Second
+
+// The output of a jq expression.
+type jqResult struct {
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Icon    string `json:"icon"`
+	Urgency string `json:"urgency"`
+}
+
+// Parse and compile a jq expression, with `$topic` as the only variable.
+func compileJQ(expr string) (*gojq.Code, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query, gojq.WithVariables([]string{"$topic"}))
+}
+
+// Run the jq expression for a message.
+// Returns nil if the expression produces `null` or nothing,
+// i.e. no notification should be shown.
+func (s *Subscription) evalJQ(ctx *TemplateContext) (*jqResult, error) {
+	// decoded again, the template data may contain parsed timestamps
+	input, err := decodeJSON(ctx.payload)
+	if err != nil {
+		return nil, fmt.Errorf("jq input is not JSON: %v", err)
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), jqTimeout)
+	defer cancel()
+
+	// only the first output is used
+	output, ok := s.jqCode.RunWithContext(c, input, ctx.topic).Next()
+	if !ok || output == nil {
+		return nil, nil
+	}
+	if err, ok := output.(error); ok {
+		if c.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("jq did not finish within %v", jqTimeout)
+		}
+		return nil, fmt.Errorf("jq failed: %v", err)
+	}
+	if _, ok := output.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("jq output is not an object with title and body: %v", output)
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	var result jqResult
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("jq output is not an object with title and body: %s", data)
+	}
+	return &result, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Liveness Probe -------------------------------------------------------------
+//
+// The notifications service is checked periodically with a call to
+// GetServerInformation, to find out if it hangs.
+// The result is available in the status.
+
+const defaultProbeInterval = 60 // seconds
+const probeTimeout = 5 * time.Second
+
+// Result of the last liveness check.
+type serverHealth struct {
+	ok      bool
+	server  string // name and version of the notifications server
+	err     string
+	checked time.Time
+}
+
+var health = serverHealth{ok: true}
+var healthMutex sync.Mutex
+
+// Start checking the notifications service in the background.
+func startLivenessProbe() {
+	config := currentConfig()
+	interval := time.Duration(defaultProbeInterval) * time.Second
+	if config.ProbeInterval > 0 {
+		interval = time.Duration(config.ProbeInterval) * time.Second
+	} else if config.ProbeInterval < 0 {
+		return // disabled
+	}
+
+	go func() {
+		for {
+			checkLiveness()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Call GetServerInformation and record the result.
+func checkLiveness() {
+	replies := make(chan *dbus.Call, 1)
+	// do not start the service, this is only a check
+	notifications.Go("org.freedesktop.Notifications.GetServerInformation",
+		dbus.FlagNoAutoStart, replies)
+
+	result := serverHealth{checked: time.Now()}
+	select {
+	case call := <-replies:
+		var name, vendor, version, specVersion string
+		err := call.Err
+		if err == nil {
+			err = call.Store(&name, &vendor, &version, &specVersion)
+		}
+		if err != nil {
+			result.err = err.Error()
+		} else {
+			result.ok = true
+			result.server = fmt.Sprintf("%v %v", name, version)
+		}
+	case <-time.After(probeTimeout):
+		result.err = fmt.Sprintf("no response within %v", probeTimeout)
+	}
+
+	healthMutex.Lock()
+	previous := health
+	health = result
+	healthMutex.Unlock()
+
+	if previous.ok && !result.ok {
+		log.Printf("WARNING: %v is not responding: %v", DESTINATION, result.err)
+	} else if !previous.ok && result.ok {
+		log.Printf("%v is responding again", DESTINATION)
+	}
+}
+
+// Get the result of the last liveness check.
+func serverStatus() serverHealth {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	return health
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Locales --------------------------------------------------------------------
+
+// Formatting conventions for a locale.
+type Locale struct {
+	Decimal    string
+	Thousands  string
+	Clock24    bool
+	DateFormat string
+	Weekdays   [7]string
+}
+
+var englishWeekdays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday",
+	"Thursday", "Friday", "Saturday"}
+
+// Known locales, by language or language_TERRITORY.
+var locales = map[string]*Locale{
+	"en":    &Locale{".", ",", false, "01/02/2006", englishWeekdays},
+	"en_GB": &Locale{".", ",", true, "02/01/2006", englishWeekdays},
+	"en_IE": &Locale{".", ",", true, "02/01/2006", englishWeekdays},
+	"en_AU": &Locale{".", ",", false, "02/01/2006", englishWeekdays},
+	"de": &Locale{",", ".", true, "02.01.2006", [7]string{"Sonntag", "Montag",
+		"Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"}},
+	"de_CH": &Locale{".", "'", true, "02.01.2006", [7]string{"Sonntag", "Montag",
+		"Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"}},
+	"fr": &Locale{",", " ", true, "02/01/2006", [7]string{"dimanche", "lundi",
+		"mardi", "mercredi", "jeudi", "vendredi", "samedi"}},
+	"es": &Locale{",", ".", true, "02/01/2006", [7]string{"domingo", "lunes",
+		"martes", "miércoles", "jueves", "viernes", "sábado"}},
+	"it": &Locale{",", ".", true, "02/01/2006", [7]string{"domenica", "lunedì",
+		"martedì", "mercoledì", "giovedì", "venerdì", "sabato"}},
+	"nl": &Locale{",", ".", true, "02-01-2006", [7]string{"zondag", "maandag",
+		"dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"}},
+	"pl": &Locale{",", " ", true, "02.01.2006", [7]string{"niedziela",
+		"poniedziałek", "wtorek", "środa", "czwartek", "piątek", "sobota"}},
+	"sv": &Locale{",", " ", true, "2006-01-02", [7]string{"söndag", "måndag",
+		"tisdag", "onsdag", "torsdag", "fredag", "lördag"}},
+}
+
+// Find the locale for the given category (e.g. "LC_TIME").
+// Uses the `locale` from config if set, else the usual environment variables.
+// Falls back to English if the locale is unknown.
+func localeFor(category string) *Locale {
+	config := currentConfig()
+	name := config.Locale
+	if name == "" {
+		for _, key := range []string{"LC_ALL", category, "LANG"} {
+			name = os.Getenv(key)
+			if name != "" {
+				break
+			}
+		}
+	}
+	return lookupLocale(name)
+}
+
+// Find a locale by its POSIX name, like "de_DE.UTF-8".
+func lookupLocale(name string) *Locale {
+	// strip encoding and modifier
+	if i := strings.IndexAny(name, ".@"); i != -1 {
+		name = name[:i]
+	}
+
+	if loc, ok := locales[name]; ok {
+		return loc
+	}
+	lang := strings.SplitN(name, "_", 2)[0]
+	if loc, ok := locales[lang]; ok {
+		return loc
+	}
+	return locales["en"]
+}
@@ -2,81 +2,220 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
+	"unicode"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	dbus "github.com/godbus/dbus"
+	"github.com/itchyny/gojq"
 )
 
 const NOTIFY_METHOD = "org.freedesktop.Notifications.Notify"
+const SIGNAL_CLOSED = "org.freedesktop.Notifications.NotificationClosed"
+const SIGNAL_ACTION = "org.freedesktop.Notifications.ActionInvoked"
+const SIGNAL_OWNER_CHANGED = "org.freedesktop.DBus.NameOwnerChanged"
 const APPNAME = "mqtt-dbus-notify"
 const DESTINATION = "org.freedesktop.Notifications"
 const OBJ_PATH = dbus.ObjectPath("/org/freedesktop/Notifications")
 
-var config *Config
+// The active configuration. It is replaced as a whole on reload,
+// so get it once with `currentConfig` and keep using that snapshot.
+var activeConfig *Config
+var configMutex sync.RWMutex
 var dbusConn *dbus.Conn
 var notifications dbus.BusObject
 var mqttClient mqtt.Client
 var subscribed = make([]string, 0)
+var subscribedMutex sync.Mutex
+
+// Held while topics are (un)subscribed, so that a reload, a change of the
+// idle state, a reconnect and retries do not interleave.
+var subscriptionMutex sync.Mutex
+
+// Incremented when all subscriptions are renewed,
+// which cancels the retries started before.
+var subscriptionRound int
+
+var systemMode = flag.Bool("system", false,
+	"Run as system service, send notifications to session helpers")
+var helperMode = flag.Bool("helper", false,
+	"Run as session helper for the system service")
+var capturePath = flag.String("capture", "",
+	"Record matching messages to `file`")
+var replayPath = flag.String("replay", "",
+	"Show messages from a capture `file` instead of connecting to MQTT")
+var observeMode = flag.Bool("observe", false,
+	"Print what incoming messages would show instead of showing them")
+var busAddress = flag.String("bus", "",
+	"D-Bus `address` to use instead of the session bus, \"system\" for the system bus")
 
 func main() {
-	err := run()
+	flag.Parse()
+
+	var err error
+	if flag.Arg(0) == "status" {
+		err = printStatus()
+	} else if flag.Arg(0) == "schema" {
+		err = printSchema()
+	} else if flag.Arg(0) == "prune" {
+		err = pruneConfig()
+	} else if flag.Arg(0) == "probe" {
+		err = probeServer()
+	} else if flag.Arg(0) == "test" {
+		err = runTests(flag.Arg(1))
+	} else if *observeMode {
+		err = runObserve()
+	} else if *helperMode {
+		err = runHelper()
+	} else {
+		err = run()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func run() error {
-	// setup channel to receive SIGINT (ctrl+c)
+	// setup channel to receive SIGINT (ctrl+c),
+	// SIGUSR1/SIGUSR2 to pause/resume and SIGHUP to reload
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	signal.Notify(signals, os.Interrupt, syscall.SIGUSR1, syscall.SIGUSR2,
+		syscall.SIGHUP)
 
 	err := loadConfig()
 	if err != nil {
 		return err
 	}
+	config := currentConfig()
 
-	err = connectDBus()
+	err = loadState()
 	if err != nil {
-		return err
+		log.Printf("WARNING: Failed to load state: %v", err)
 	}
-	defer disconnectDBus()
 
-	err = connectMQTT()
-	if err != nil {
-		return err
+	// signals which arrive before the program is ready
+	var deferred []os.Signal
+	if *systemMode {
+		err = listenSessions()
+		if err != nil {
+			return err
+		}
+		defer closeSessions()
+	} else {
+		deferred, err = retry("Connect to D-Bus", connectDBus, signals)
+		if err != nil {
+			return err
+		}
+		defer disconnectDBus()
+
+		err = exportService()
+		if err != nil {
+			log.Printf("WARNING: Failed to export status service: %v", err)
+		}
+
+		err = listenSignals()
+		if err != nil {
+			warnStartup("Failed to listen for notification actions: %v", err)
+		}
+
+		err = checkService()
+		if err != nil {
+			warnStartup("Failed to check for %v: %v", DESTINATION, err)
+		}
+		startLivenessProbe()
 	}
-	defer disconnectMQTT()
 
-	err = subscribe()
-	if err != nil {
-		return err
+	if *replayPath != "" {
+		return replay(*replayPath)
+	}
+
+	if *capturePath != "" {
+		err = openCapture(*capturePath)
+		if err != nil {
+			return err
+		}
+		defer closeCapture()
+	}
+
+	// an empty host disables MQTT, e.g. if only ntfy is used
+	if config.Host != "" {
+		err = connectMQTT()
+		if err != nil {
+			return err
+		}
+		defer disconnectMQTT()
+
+		if mqttClient.IsConnectionOpen() {
+			subscribeOnce.Do(subscribe)
+		}
+		defer unsubscribe()
+		startIdleMonitor()
 	}
-	defer unsubscribe()
 
+	startNtfy()
+
+	if config.Hello {
+		sayHello()
+	}
+	showStartupWarnings()
+
+	for _, sig := range deferred {
+		handleSignal(sig)
+	}
 	// blocks until SIGINT
-	_ = <-signals
+	for handleSignal(<-signals) {
+	}
+	drain()
 	return nil
 }
 
+// Pause, resume or reload for a signal.
+// Returns false if the program should stop.
+func handleSignal(sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGUSR1:
+		pause()
+	case syscall.SIGUSR2:
+		resume()
+	case syscall.SIGHUP:
+		err := reloadConfig()
+		if err != nil {
+			log.Printf("ERROR: Failed to reload configuration: %v", err)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 // DBUS -----------------------------------------------------------------------
 
 // Connect to the D-Bus session bus
 // and initialize a proxy object for the notifications service.
 func connectDBus() error {
 	log.Println("Connect to DBus...")
-	conn, err := dbus.SessionBus()
+	conn, err := openBus()
 	if err != nil {
 		return err
 	}
@@ -87,6 +226,38 @@ func connectDBus() error {
 	return nil
 }
 
+// Connect to the session bus, unless a different bus is set with the
+// -bus flag or `dbus_address` (e.g. when started from cron or a container).
+// "system" is the system bus.
+func openBus() (*dbus.Conn, error) {
+	config := currentConfig()
+	address := *busAddress
+	if address == "" && config != nil {
+		address = config.DBusAddress
+	}
+
+	switch address {
+	case "":
+		return dbus.SessionBus()
+	case "system":
+		return dbus.SystemBus()
+	}
+
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Auth(nil)
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
 // Disconnect from D-Bus session bus.
 func disconnectDBus() {
 	if dbusConn != nil {
@@ -95,38 +266,281 @@ func disconnectDBus() {
 	}
 }
 
+// Subscribe to signals from the notifications service
+// to learn when the user interacts with our notifications.
+// Also watches the service itself, to learn when it (re)appears.
+func listenSignals() error {
+	rules := []string{
+		"type='signal',interface='" + DESTINATION + "'",
+		"type='signal',interface='org.freedesktop.DBus'," +
+			"member='NameOwnerChanged',arg0='" + DESTINATION + "'",
+	}
+	for _, rule := range rules {
+		call := dbusConn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule)
+		if call.Err != nil {
+			return call.Err
+		}
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signals)
+	go handleSignals(signals)
+	return nil
+}
+
+// Handle incoming signals until the channel is closed.
+func handleSignals(signals <-chan *dbus.Signal) {
+	for sig := range signals {
+		if len(sig.Body) < 2 {
+			continue
+		}
+
+		switch sig.Name {
+		case SIGNAL_CLOSED:
+			// IDs are only unique per server
+			id, _ := sig.Body[0].(uint32)
+			ref := notificationRef{sig.Sender, id}
+			// reason 2: dismissed by the user
+			reason, _ := sig.Body[1].(uint32)
+			if reason == 2 {
+				markRead(ref)
+			}
+			forgetNotification(ref)
+			emit(Event{Type: eventClosed, ID: id, Server: sig.Sender, Reason: reason})
+			forgetActions(ref)
+			overflowClosed(ref, reason)
+		case SIGNAL_ACTION:
+			id, _ := sig.Body[0].(uint32)
+			ref := notificationRef{sig.Sender, id}
+			markRead(ref)
+			action, _ := sig.Body[1].(string)
+			emit(Event{Type: eventAction, ID: id, Server: sig.Sender, Action: action})
+			invokeAction(ref, action)
+		case SIGNAL_OWNER_CHANGED:
+			if len(sig.Body) < 3 {
+				continue
+			}
+			name, _ := sig.Body[0].(string)
+			owner, _ := sig.Body[2].(string)
+			if name == DESTINATION {
+				setServiceAvailable(owner != "")
+			}
+		}
+	}
+}
+
+// A desktop notification.
+type Notification struct {
+	Title    string    `json:"title"`
+	Body     string    `json:"body"`
+	Icon     string    `json:"icon"`
+	Replaces uint32    `json:"-"`        // ID of the notification to replace, 0 for none
+	Actions  []string  `json:"actions"`  // pairs of action key and label
+	Urgency  string    `json:"urgency"`  // "low", "normal", "critical" or empty
+	Timeout  *int32    `json:"timeout"`  // milliseconds, nil for the default
+	Sound    string    `json:"sound"`    // name from the sound naming spec
+	Silent   bool      `json:"silent"`   // suppress the sound
+	Progress *int32    `json:"progress"` // percent, for a progress bar
+	Position *Position `json:"position"` // for servers which support it
+	Server   string    `json:"server"`   // bus name of the notifications server
+	Badge    string    `json:"badge"`    // text for a generated icon
+	// "fgcolor", "bgcolor" or "frcolor" and a color like "#ff0000"
+	Colors map[string]string `json:"colors"`
+}
+
+// Add an action, unless there is already one with the same key.
+func (n *Notification) addAction(key, label string) {
+	for i := 0; i < len(n.Actions); i += 2 {
+		if n.Actions[i] == key {
+			return
+		}
+	}
+	n.Actions = append(n.Actions, key, label)
+}
+
+// Retries for failed notifications, the first after `notifyBackoff`.
+const defaultNotifyRetries = 3
+const notifyBackoff = 500 * time.Millisecond
+
+// How long notifications are displayed, in milliseconds.
+const defaultNotifyTimeout = int32(7000)
+
+var urgencyLevels = map[string]byte{
+	"low":      0,
+	"normal":   1,
+	"critical": 2,
+}
+
 // Send a notifcation through the D-Bus notifications service.
-func notify(title, body, icon string) error {
-	call := notifications.Call(NOTIFY_METHOD, 0, APPNAME, uint32(0),
-		icon, title, body,
-		[]string{}, map[string]dbus.Variant{}, int32(7000))
+// Returns the ID of the new notification.
+//
+// In system mode, the notification is sent to all session helpers instead
+// and the returned ID is always 0.
+func notify(n *Notification) (uint32, error) {
+	if *observeMode {
+		// nothing is shown while observing
+		return 0, nil
+	}
+	if *systemMode {
+		return 0, broadcast(n)
+	}
+
+	call := notificationServer(n.Server).Call(NOTIFY_METHOD, 0, notifyArgs(n)...)
+	if call.Err != nil {
+		return 0, call.Err
+	}
+
+	var id uint32
+	err := call.Store(&id)
+	return id, err
+}
+
+// Send a notification without waiting for the reply,
+// so that a slow notifications service does not hold up other messages.
+// `done` is called with the ID of the notification when the reply arrives.
+func notifyAsync(n *Notification, done func(uint32, error)) {
+	if *systemMode {
+		done(0, broadcast(n))
+		return
+	}
+
+	replies := make(chan *dbus.Call, 1)
+	notificationServer(n.Server).Go(NOTIFY_METHOD, 0, replies, notifyArgs(n)...)
+	go func() {
+		call := <-replies
+		if call.Err != nil {
+			done(0, call.Err)
+			return
+		}
+		var id uint32
+		err := call.Store(&id)
+		done(id, err)
+	}()
+}
+
+// The arguments for the Notify method.
+func notifyArgs(n *Notification) []interface{} {
+	actions := n.Actions
+	if actions == nil {
+		actions = []string{}
+	}
+	hints := map[string]dbus.Variant{}
+	if level, ok := urgencyLevels[n.Urgency]; ok {
+		hints["urgency"] = dbus.MakeVariant(level)
+	}
+	if n.Sound != "" {
+		hints["sound-name"] = dbus.MakeVariant(n.Sound)
+	}
+	if n.Silent {
+		hints["suppress-sound"] = dbus.MakeVariant(true)
+	}
+	if n.Progress != nil {
+		hints["value"] = dbus.MakeVariant(*n.Progress)
+	}
+	if n.Badge != "" {
+		hints["image-data"] = badgeImage(n.Badge)
+	}
+	for hint, color := range n.Colors {
+		hints[hint] = dbus.MakeVariant(color)
+	}
+	if n.Position != nil {
+		hints["x"] = dbus.MakeVariant(n.Position.X)
+		hints["y"] = dbus.MakeVariant(n.Position.Y)
+	}
+
+	timeout := defaultNotifyTimeout
+	if n.Timeout != nil {
+		timeout = *n.Timeout
+	}
+
+	return []interface{}{APPNAME, n.Replaces, n.Icon, n.Title, n.Body,
+		actions, hints, timeout}
+}
+
+// Send a notification, retrying with increasing delays if it fails,
+// e.g. while the compositor restarts.
+func notifyWithRetry(n *Notification) (uint32, error) {
+	config := currentConfig()
+	retries := defaultNotifyRetries
+	if config.NotifyRetries != nil {
+		retries = *config.NotifyRetries
+	}
+
+	backoff := notifyBackoff
+	for attempt := 1; ; attempt++ {
+		id, err := notify(n)
+		if err == nil {
+			return id, nil
+		}
+		if attempt > retries {
+			return 0, fmt.Errorf("%v (after %d attempts)", err, attempt)
+		}
+		log.Printf("WARNING: Failed to send notification, retry in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
 
-	return call.Err
+// Show a notification to confirm that we are up and running.
+func sayHello() {
+	config := currentConfig()
+	_, err := notify(&Notification{
+		Title: APPNAME,
+		Body: fmt.Sprintf("Connected to %v:%v, subscribed to %d topics",
+			config.Host, config.Port, len(subscribedTopics())),
+		Icon: config.Icon,
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+	}
 }
 
 // MQTT -----------------------------------------------------------------------
 
+const transportTCP = "tcp"
+const transportWebsocket = "websocket"
+
 // Connect to the MQTT broker from config
 func connectMQTT() error {
-	log.Println("Connect to MQTT ...")
+	config := currentConfig()
 	opts := mqtt.NewClientOptions()
 
-	var scheme string
+	scheme := "tcp"
+	if config.Transport == transportWebsocket {
+		scheme = "ws"
+	}
 	if config.Secure {
-		scheme = "tcps"
-	} else {
-		scheme = "tcp"
+		scheme += "s"
+		t, err := tlsConfig()
+		if err != nil {
+			return err
+		}
+		opts.SetTLSConfig(t)
 	}
 	url := fmt.Sprintf("%v://%v:%v", scheme, config.Host, config.Port)
+	if config.Transport == transportWebsocket && config.Path != "" {
+		url += "/" + strings.TrimPrefix(config.Path, "/")
+	}
 	opts.AddBroker(url)
+	setBrokerURL(url)
+	log.Printf("Connect to MQTT at %v ...", url)
 
 	if config.Username != "" {
 		opts.SetUsername(config.Username)
 		opts.SetPassword(config.Password)
 	}
 
+	opts.SetDefaultPublishHandler(onMessage)
+	if config.OrderMatters != nil {
+		opts.SetOrderMatters(*config.OrderMatters)
+	}
 	opts.SetConnectionLostHandler(onMQTTConnectionLost)
 	opts.SetOnConnectHandler(onMQTTConnected)
+	opts.SetReconnectingHandler(onMQTTReconnecting)
+	config.Connection.apply(opts)
+	if config.Availability != nil {
+		config.Availability.apply(opts)
+	}
 
 	hostname, err := os.Hostname()
 	if err == nil {
@@ -139,250 +553,1546 @@ func connectMQTT() error {
 	timeout := time.Duration(config.Timeout) * time.Second
 	t := mqttClient.Connect()
 	if !t.WaitTimeout(timeout) {
+		if config.Connection.ConnectRetry {
+			// the client keeps trying, subscribe when connected
+			warnStartup("Could not connect to %v yet, retrying in the background", url)
+			return nil
+		}
 		return errors.New("MQTT Connect timed out")
 	}
 	return t.Error()
 }
 
 func onMQTTConnectionLost(client mqtt.Client, err error) {
-	log.Println("MQTT connection lost")
+	log.Printf("MQTT connection to %v lost: %v", brokerLabel(), err)
+	recordDisconnect(err)
+	emit(Event{Type: eventDisconnected, Error: err})
+}
+
+// The client reconnects with increasing delays,
+// up to `connection.max_reconnect_interval`.
+func onMQTTReconnecting(client mqtt.Client, opts *mqtt.ClientOptions) {
+	log.Printf("Reconnect to MQTT at %v ...", brokerLabel())
 }
 
 func onMQTTConnected(client mqtt.Client) {
-	log.Println("MQTT connected")
+	config := currentConfig()
+	log.Printf("MQTT connected to %v", brokerLabel())
+	reconnected := recordConnect()
+	go publishAvailability(client, true)
+	startGrace()
+	emit(Event{Type: eventConnected})
+	if reconnected && config.Connection.Resubscribe {
+		go renewSubscriptions()
+	} else {
+		go subscribeOnce.Do(subscribe)
+	}
 }
 
 // Disconnect from the MQTT broker
 func disconnectMQTT() {
+	config := currentConfig()
 	if mqttClient != nil {
 		if mqttClient.IsConnected() {
-			mqttClient.Disconnect(250) // 250 millis cleanup time
-			log.Println("Disconnected from MQTT")
+			publishAvailability(mqttClient, false)
+			mqttClient.Disconnect(config.Connection.DisconnectWait)
+			log.Printf("Disconnected from MQTT at %v", brokerLabel())
 		}
 	}
 }
 
+// Subscribe only once, after the first connect.
+var subscribeOnce sync.Once
+
 // Subscribe to all configured topics.
-// Stores successful subscriptions in global `subscriptions` variable.
-func subscribe() error {
+//
+// Incoming messages are handled by `onMessage`,
+// which decides which subscriptions are triggered.
+func subscribe() {
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	config := currentConfig()
 	if len(config.Subscriptions) == 0 {
 		log.Println("WARNING: No subscriptions configured.")
-		return nil
 	}
+	subscribeTopics(config.topics())
+}
 
-	timeout := time.Duration(config.Timeout) * time.Second
-	qos := byte(0)
+// Subscribe again to all topics after a reconnect.
+//
+// The broker may have lost the session, e.g. after a restart,
+// and the client cannot tell on a reconnect,
+// so the subscriptions are always renewed.
+func renewSubscriptions() {
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	config := currentConfig()
+	subscriptionRound++
+	subscribedMutex.Lock()
+	subscribed = make([]string, 0)
+	subscribedMutex.Unlock()
 
-	for _, sub := range config.Subscriptions {
-		if sub.Topic == "" {
-			log.Println("WARNING: Ignoring subscription without topic.")
-			continue
-		}
-		log.Printf("Subscribe to %s", sub.Topic)
-		s := sub // local var for scope
-		t := mqttClient.Subscribe(sub.Topic, qos, func(c mqtt.Client, m mqtt.Message) {
-			s.Trigger(m.Topic(), string(m.Payload()))
-		})
+	subscribeTopics(config.topics())
+}
 
-		if !t.WaitTimeout(timeout) {
-			return errors.New("MQTT Subscribe timed out")
-		} else if t.Error() != nil {
-			return t.Error()
+// Subscribe to the given topics.
+// Stores successful subscriptions in global `subscribed` variable.
+// A failed subscription does not affect the other topics,
+// it is retried in the background.
+func subscribeTopics(topics []string) {
+	if mqttClient == nil {
+		return
+	}
+
+	for _, topic := range topics {
+		err := subscribeTopic(topic)
+		if err != nil {
+			log.Printf("ERROR: Failed to subscribe to %v: %v", topic, err)
+			emit(Event{Type: eventSubscribeFailed, Topic: topic, Error: err})
+			go retrySubscribe(topic, subscriptionRound)
 		}
+	}
+}
 
-		subscribed = append(subscribed, sub.Topic)
+// Subscribe to a single topic.
+func subscribeTopic(topic string) error {
+	config := currentConfig()
+	log.Printf("Subscribe to %s", topic)
+	t := mqttClient.Subscribe(topic, config.topicQoS(topic), nil)
+	if !t.WaitTimeout(config.subscribeTimeout()) {
+		return errors.New("MQTT Subscribe timed out")
+	} else if t.Error() != nil {
+		return t.Error()
 	}
 
+	subscribedMutex.Lock()
+	defer subscribedMutex.Unlock()
+	subscribed = append(subscribed, topic)
 	return nil
 }
 
-// Unsubscribe from all previously subscribed topics.
-func unsubscribe() {
-	if mqttClient != nil {
-		for _, topic := range subscribed {
-			log.Printf("Unsubscribe from %s", topic)
-			mqttClient.Unsubscribe(topic)
+// Maximum delay between attempts to subscribe to a topic.
+const maxSubscribeBackoff = 5 * time.Minute
+
+// Retry a failed subscription with increasing delays.
+// Gives up if the topic is removed from the configuration
+// or if the subscriptions were renewed after `round`.
+func retrySubscribe(topic string, round int) {
+	backoff := 5 * time.Second
+	for {
+		time.Sleep(backoff)
+		if backoff < maxSubscribeBackoff {
+			backoff *= 2
+		}
+		if retrySubscribeOnce(topic, round) {
+			return
 		}
 	}
 }
 
-// Subscriptions --------------------------------------------------------------
-
-const tplTitle = "title"
-const tplBody = "body"
+// A single attempt of `retrySubscribe`.
+// Returns true if there is nothing left to do.
+func retrySubscribeOnce(topic string, round int) bool {
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	if round != subscriptionRound {
+		return true
+	}
 
-// Configuration for a single MQTT subscription.
-type Subscription struct {
-	Topic           string                        `json:"topic"`
-	Title           string                        `json:"title"`
-	Body            string                        `json:"body"`
-	Icon            string                        `json:"icon"`
-	cachedTemplates map[string]*template.Template `json:"-"`
-}
+	wanted := false
+	for _, t := range currentConfig().topics() {
+		wanted = wanted || t == topic
+	}
+	if !wanted || isSubscribed(topic) {
+		return true
+	}
 
-// Called for each incoming MQTT message that matches this subscription.
-func (s *Subscription) Trigger(topic, payload string) {
-	title, body, err := s.createTitleAndBody(topic, payload)
+	err := subscribeTopic(topic)
 	if err != nil {
-		log.Printf("ERROR: Failed to create notification: %v", err)
-		return
+		log.Printf("ERROR: Failed to subscribe to %v: %v", topic, err)
 	}
+	return err == nil
+}
 
-	icon := s.Icon
-	if icon == "" {
-		icon = config.Icon
+// Whether we are subscribed to the topic.
+func isSubscribed(topic string) bool {
+	for _, t := range subscribedTopics() {
+		if t == topic {
+			return true
+		}
 	}
-	notify(title, body, icon)
+	return false
 }
 
-// Create title and body for a notification.
-// Either from default (title=first line, body=subsequent lines)
-// or by filling the respective templates from configuration.
-func (s *Subscription) createTitleAndBody(topic, payload string) (string, string, error) {
-	title := ""
-	body := ""
-	useTemplates := s.Title != "" || s.Body != ""
+// Get a copy of the subscribed topics.
+func subscribedTopics() []string {
+	subscribedMutex.Lock()
+	defer subscribedMutex.Unlock()
+	return append([]string{}, subscribed...)
+}
 
-	if useTemplates {
-		return s.fillTemplates(topic, payload)
-	} else {
-		parts := strings.SplitN(payload, "\n", 2)
-		title = parts[0]
-		if len(parts) > 1 {
-			body = parts[1]
+// Called for every incoming MQTT message.
+func onMessage(client mqtt.Client, m mqtt.Message) {
+	enqueue(queuedMessage{
+		Topic:    m.Topic(),
+		Payload:  string(m.Payload()),
+		Retained: m.Retained(),
+		Stale:    isStale(m.Retained()),
+	})
+}
+
+// Trigger the matching subscriptions for a message, highest priority first.
+// Stops after the first matching subscription that has `stop` set.
+func dispatch(m queuedMessage) {
+	config := currentConfig()
+	topic, payload := m.Topic, m.Payload
+	for _, filter := range config.RetainedTopics {
+		if topicMatches(filter, topic) {
+			setRetained(topic, payload)
+			break
 		}
 	}
 
-	return title, body, nil
-}
+	matches := config.matchingSubscriptions(topic)
+	if len(matches) > 0 && !config.topicAllowed(topic) {
+		log.Printf("WARNING: Dropped message for %v, topic not in allowlist", topic)
+		recordError()
+		for _, sub := range matches {
+			sub.count(statReceived)
+			sub.count(statFiltered)
+		}
+		return
+	}
+	if len(matches) > 0 {
+		capture(topic, payload)
+	}
+	if len(matches) > 0 && m.Stale && dropStale() {
+		for _, sub := range matches {
+			sub.count(statReceived)
+			sub.count(statFiltered)
+		}
+		return
+	}
 
-// Prepare (parse) templates if not already cached.
-func (s *Subscription) prepareTemplates() error {
-	if s.cachedTemplates != nil {
-		return nil
+	if holdUntilAvailable(m) || holdMessage(m) {
+		return
 	}
 
-	var err error
-	templates := []string{tplTitle, tplBody}
-	s.cachedTemplates = make(map[string]*template.Template, len(templates))
-
-	for _, name := range templates {
-		tpl := template.New(name)
-		var raw string
-		if name == tplTitle {
-			raw = s.Title
-		} else {
-			raw = s.Body
+	for _, sub := range matches {
+		sub.Trigger(m)
+		if sub.Stop {
+			break
 		}
-		_, err = tpl.Parse(raw)
-		if err != nil {
-			return err
+	}
+}
+
+// Find all subscriptions whose topic filter matches the given topic,
+// ordered by priority. Subscriptions with the same priority
+// keep the order from the configuration.
+func (c *Config) matchingSubscriptions(topic string) []*Subscription {
+	matches := make([]*Subscription, 0)
+	for _, sub := range c.Subscriptions {
+		if sub.matches(topic) {
+			matches = append(matches, sub)
 		}
-		s.cachedTemplates[name] = tpl
 	}
-	return nil
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Priority > matches[j].Priority
+	})
+	return matches
 }
 
-func (s *Subscription) fillTemplates(topic, payload string) (string, string, error) {
-	err := s.prepareTemplates()
-	if err != nil {
-		return "", "", err
+// Check the topic against the `topic_allowlist`.
+// All topics are allowed if there is no allowlist.
+func (c *Config) topicAllowed(topic string) bool {
+	if len(c.TopicAllowlist) == 0 || isInternal(topic) {
+		return true
+	}
+	for _, filter := range c.TopicAllowlist {
+		if topicMatches(filter, topic) {
+			return true
+		}
 	}
+	return false
+}
 
-	var title, body string
-	ctx := NewTemplateContext(topic, payload)
+// Check if a topic matches a topic filter with `+` and `#` wildcards.
+func topicMatches(filter, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
 
-	for name, tpl := range s.cachedTemplates {
-		buf := new(bytes.Buffer)
-		err = tpl.Execute(buf, &ctx)
-		if err != nil {
-			return "", "", err
+	// wildcards do not match topics starting with "$"
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
 		}
-		if name == tplTitle {
-			title = buf.String()
-		} else {
-			body = buf.String()
+		if i >= len(topicParts) {
+			return false
+		}
+		if part != "+" && part != topicParts[i] {
+			return false
 		}
 	}
-
-	return title, body, nil
+	return len(filterParts) == len(topicParts)
 }
 
-type TemplateContext struct {
-	payload string
-	parts   []string
+// Unsubscribe from all previously subscribed topics.
+func unsubscribe() {
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	unsubscribeTopics(subscribedTopics())
 }
 
-func NewTemplateContext(topic, payload string) TemplateContext {
-	return TemplateContext{
-		payload: payload,
-		parts:   strings.Split(topic, "/"),
+// Unsubscribe from the given topics
+// and remove them from the global `subscribed` variable.
+func unsubscribeTopics(topics []string) {
+	config := currentConfig()
+	if mqttClient == nil {
+		return
 	}
-}
 
-func (t *TemplateContext) Topic(index int) (string, error) {
-	if index < 0 || index > len(t.parts) {
-		return "", errors.New("Invalid topic index")
+	remove := make(map[string]bool)
+	for _, topic := range topics {
+		log.Printf("Unsubscribe from %s", topic)
+		t := mqttClient.Unsubscribe(topic)
+		if !t.WaitTimeout(config.subscribeTimeout()) {
+			log.Printf("WARNING: Unsubscribe from %v timed out", topic)
+		} else if t.Error() != nil {
+			log.Printf("WARNING: Failed to unsubscribe from %v: %v", topic, t.Error())
+		}
+		remove[topic] = true
 	}
 
-	return t.parts[index], nil
+	subscribedMutex.Lock()
+	defer subscribedMutex.Unlock()
+	remaining := make([]string, 0)
+	for _, topic := range subscribed {
+		if !remove[topic] {
+			remaining = append(remaining, topic)
+		}
+	}
+	subscribed = remaining
 }
 
-func (t *TemplateContext) String() string {
-	return t.payload
-}
+// Subscriptions --------------------------------------------------------------
 
-// Config ---------------------------------------------------------------------
+const templateErrorDrop = "drop"
+const templateErrorRaw = "raw"
+const templateErrorNotify = "notify"
 
-// Configuration options
-type Config struct {
-	Host          string          `json:"host"`
-	Port          int             `json:"port"`
-	Username      string          `json:"username"`
-	Password      string          `json:"password"`
-	Secure        bool            `json:"secure"`
-	Timeout       int             `json:"timeout"`
-	Icon          string          `json:"icon"`
-	Subscriptions []*Subscription `json:"subscriptions"`
+const tplTitle = "title"
+const tplBody = "body"
+const defaultTimestampFormat = "15:04:05"
+const ellipsis = "…"
+
+const maxPayloadSize = 1 << 20
+
+var errPayloadTooLarge = fmt.Errorf("Decompressed payload exceeds %d bytes", maxPayloadSize)
+
+// Configuration for a single MQTT subscription.
+type Subscription struct {
+	Name            string                  `json:"name"`
+	Topic           string                  `json:"topic"`
+	Topics          []string                `json:"topics"`
+	QoS             *int                    `json:"qos"`
+	IgnoreRetained  *bool                   `json:"ignore_retained"`
+	Meta            map[string]string       `json:"meta"`
+	Title           string                  `json:"title"`
+	Body            string                  `json:"body"`
+	Icon            string                  `json:"icon"`
+	Image           string                  `json:"image"`
+	Urgency         string                  `json:"urgency"`
+	Replace         bool                    `json:"replace"`
+	ReplaceGroup    string                  `json:"replace_group"`
+	ShowUnread      bool                    `json:"show_unread"`
+	IdleUnsubscribe bool                    `json:"idle_unsubscribe"`
+	Sound           string                  `json:"sound"`
+	Position        *Position               `json:"position"`
+	Badge           string                  `json:"badge"`
+	FgColor         string                  `json:"fgcolor"`
+	BgColor         string                  `json:"bgcolor"`
+	FrColor         string                  `json:"frcolor"`
+	Server          string                  `json:"server"`
+	SoundEvery      *int                    `json:"sound_every"`
+	ShowTimestamp   bool                    `json:"show_timestamp"`
+	TimestampFormat string                  `json:"timestamp_format"`
+	Tags            []string                `json:"tags"`
+	Emoji           bool                    `json:"emoji"`
+	MaxTitleLen     int                     `json:"max_title_len"`
+	MaxBodyLen      int                     `json:"max_body_len"`
+	AttachFull      bool                    `json:"attach_full"`
+	Compression     string                  `json:"compression"`
+	TopicDecoding   string                  `json:"topic_decoding"`
+	ContentType     string                  `json:"content_type"`
+	Engine          string                  `json:"engine"`
+	JQ              string                  `json:"jq"`
+	Schema          string                  `json:"schema"`
+	OnInvalid       string                  `json:"on_invalid"`
+	InvalidTitle    string                  `json:"invalid_title"`
+	InvalidBody     string                  `json:"invalid_body"`
+	MaxAge          int                     `json:"max_age"`
+	OnExpired       string                  `json:"on_expired"`
+	ParseTimestamps bool                    `json:"parse_timestamps"`
+	FeedbackTopic   string                  `json:"feedback_topic"`
+	ValueField      string                  `json:"value_field"`
+	Aggregate       *AggregateConfig        `json:"aggregate"`
+	Threshold       *ThresholdConfig        `json:"threshold"`
+	Progress        *ProgressConfig         `json:"progress"`
+	Pair            *PairConfig             `json:"pair"`
+	OnChange        bool                    `json:"on_change"`
+	Schedule        []string                `json:"schedule"`
+	QuietHours      *QuietHours             `json:"quiet_hours"`
+	Power           *PowerCondition         `json:"power"`
+	Priority        int                     `json:"priority"`
+	Stop            bool                    `json:"stop"`
+	Webhook         *Webhook                `json:"webhook"`
+	ClearWhen       string                  `json:"clear_when"`
+	Copy            string                  `json:"copy"`
+	Open            string                  `json:"open"`
+	Link            string                  `json:"link"`
+	LinkLabel       string                  `json:"link_label"`
+	Transform       []*TransformStep        `json:"transform"`
+	ValueMap        map[string]string       `json:"value_map"`
+	aggregations    map[string]*aggregation `json:"-"`
+	thresholdStates map[string]string       `json:"-"`
+	lastValues      map[string]string       `json:"-"`
+	memberValues    map[string]string       `json:"-"`
+	clearPattern    *regexp.Regexp          `json:"-"`
+	jqCode          *gojq.Code              `json:"-"`
+	progressTimers  map[string]*time.Timer  `json:"-"`
+	stats           map[string]int64        `json:"-"`
+	templates       templateCache           `json:"-"`
+	mutex           sync.Mutex              `json:"-"`
+	config          *Config                 `json:"-"` // the configuration it belongs to
+	preset          string                  `json:"-"` // name of the preset it comes from
 }
 
-// Read configuration from the default path and set global `config` variable.
-func loadConfig() error {
-	// initialize with defaults
-	config = &Config{
-		Host:          "localhost",
-		Port:          1883,
-		Username:      "",
-		Password:      "",
-		Secure:        false,
-		Timeout:       5,
-		Icon:          "dialog-information",
-		Subscriptions: []*Subscription{},
+// Called for each incoming MQTT message that matches this subscription.
+func (s *Subscription) Trigger(m queuedMessage) {
+	topic := m.Topic
+	defer s.recoverPanic(topic, m.Payload)
+	emit(Event{Type: eventReceived, Topic: topic, Subscription: s})
+	if m.Retained && s.ignoresRetained() {
+		s.count(statFiltered)
+		emit(Event{Type: eventSuppressed, Topic: topic, Subscription: s})
+		return
 	}
-
-	currentUser, err := user.Current()
-	if err != nil {
-		return err
+	ctx, ok := s.accept(topic, m.Payload)
+	if !ok {
+		emit(Event{Type: eventSuppressed, Topic: topic, Subscription: s})
+		return
 	}
+	ctx.stale = m.Stale
+	if s.expired(ctx) {
+		if s.OnExpired != stalePolicyMark {
+			s.count(statFiltered)
+			return
+		}
+		ctx.stale = true
+	}
+
+	if s.clears(ctx) {
+		s.clear(topic)
+		s.count(statFiltered)
+		return
+	}
+	if s.Pair != nil && !s.checkPair(ctx) {
+		s.count(statFiltered)
+		return
+	}
+
+	if s.Aggregate != nil {
+		s.aggregate(ctx)
+		return
+	}
+
+	s.show(ctx)
+	if s.Progress != nil {
+		s.checkCompletion(ctx)
+	}
+}
+
+// Whether retained messages are skipped for this subscription,
+// from `ignore_retained` or the global default.
+func (s *Subscription) ignoresRetained() bool {
+	config := s.config
+	if s.IgnoreRetained != nil {
+		return *s.IgnoreRetained
+	}
+	return config.IgnoreRetained
+}
+
+// Recover from a panic while handling a message,
+// so that it affects only this message. Must be deferred.
+func (s *Subscription) recoverPanic(topic, payload string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("ERROR: Panic in %v while handling message on %v (%q): %v\n%s",
+		s.label(), topic, truncate(payload, 80), r, debug.Stack())
+	s.count(statPanics)
+}
+
+// Prepare the template context for a message
+// and check whether it should produce a notification.
+// Returns false if the message is filtered or cannot be decoded.
+func (s *Subscription) accept(topic, payload string) (*TemplateContext, bool) {
+	if !s.active(time.Now()) || !s.powerAllows() {
+		s.count(statFiltered)
+		return nil, false
+	}
+
+	payload, err := decompress(payload, s.Compression)
+	if err == errPayloadTooLarge {
+		log.Printf("WARNING: %v: Dropped message on %v: %v", s.label(), topic, err)
+		s.count(statErrors)
+		return nil, false
+	} else if err != nil {
+		log.Printf("ERROR: %v: Failed to decompress payload for %v: %v", s.label(), topic, err)
+		s.count(statErrors)
+		return nil, false
+	}
+
+	if mapped, ok := s.ValueMap[strings.TrimSpace(payload)]; ok {
+		payload = mapped
+	}
+
+	payload, err = s.transform(payload)
+	if err != nil {
+		log.Printf("ERROR: %v: Failed to transform payload for %v: %v", s.label(), topic, err)
+		s.count(statErrors)
+		return nil, false
+	}
+
+	contentType := s.ContentType
+	if contentType == "" {
+		contentType = detectContentType(payload)
+	}
+
+	ctx := NewTemplateContext(topic, payload, contentType)
+	ctx.parts = decodeTopicParts(ctx.parts, s.TopicDecoding)
+	ctx.timestamps = s.ParseTimestamps
+	ctx.sub = s
+	if s.Schema != "" && !s.checkSchema(&ctx) {
+		return nil, false
+	}
+	if s.composite() {
+		ctx.values = s.updateValues(topic, payload)
+	}
+
+	if s.OnChange && !s.changed(&ctx) {
+		s.count(statFiltered)
+		return nil, false
+	}
+
+	if s.Threshold != nil && !s.checkThreshold(&ctx) {
+		s.count(statFiltered)
+		return nil, false
+	}
+
+	return &ctx, true
+}
+
+// Create and send the notification for a message.
+func (s *Subscription) show(ctx *TemplateContext) {
+	config := s.config
+	start := time.Now()
+	n, handlers, err := s.render(ctx)
+	s.observeRender(time.Since(start))
+	if err != nil {
+		log.Printf("ERROR: %v: Failed to create notification: %v", s.label(), err)
+		s.count(statErrors)
+		if config.ErrorNotifications || config.OnTemplateError == templateErrorNotify {
+			notifyError(s, err)
+		}
+		return
+	}
+	if n == nil {
+		// the templates decided not to show anything
+		s.count(statFiltered)
+		return
+	}
+
+	if s.Webhook != nil {
+		err = s.Webhook.send(ctx)
+		if err != nil {
+			log.Printf("ERROR: %v: Failed to send webhook: %v", s.label(), err)
+			s.count(statErrors)
+		}
+	}
+
+	// too many notifications on screen?
+	if n.Replaces == 0 || !isDisplayed(notificationRef{serverOwner(n.Server), n.Replaces}) {
+		if holdOverflow(func() { s.deliver(ctx.topic, n, handlers) }) {
+			s.count(statLimited)
+			return
+		}
+	}
+
+	s.deliver(ctx.topic, n, handlers)
+}
+
+// Create the notification for a message, along with handlers for its actions.
+// For preset subscriptions, returns no notification
+// if the title template renders to an empty string.
+func (s *Subscription) render(ctx *TemplateContext) (*Notification, map[string]func(), error) {
+	config := s.config
+	topic := ctx.topic
+	var title, body string
+	var computed *jqResult
+	var err error
+	if s.Engine == engineJQ && ctx.schemaError == nil {
+		computed, err = s.evalJQ(ctx)
+		if err != nil || computed == nil {
+			return nil, nil, err
+		}
+		title, body = computed.Title, computed.Body
+	} else {
+		title, body, err = s.createTitleAndBody(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.preset != "" && strings.TrimSpace(title) == "" {
+			return nil, nil, nil
+		}
+	}
+
+	if s.Emoji {
+		title = expandShortcodes(title)
+		body = expandShortcodes(body)
+	}
+	title = prependTags(title, s.Tags)
+	maxBody := s.MaxBodyLen
+	if s.ShowTimestamp && maxBody > 0 {
+		// keep room for the timestamp on a line of its own
+		stamp := appendTimestamp("", ctx.received, s.TimestampFormat)
+		maxBody -= len([]rune(stamp)) + 1
+		if maxBody < 1 {
+			maxBody = 1
+		}
+	}
+	shortBody := truncate(body, maxBody)
+	truncated := shortBody != body
+	body = shortBody
+
+	if s.ShowTimestamp {
+		body = appendTimestamp(body, ctx.received, s.TimestampFormat)
+	}
+
+	icon := s.Icon
+	if computed != nil && computed.Icon != "" {
+		icon = computed.Icon
+	}
+	if isImage(ctx.contentType) {
+		icon, err = saveImage(ctx.payload, ctx.contentType)
+		if err != nil {
+			log.Printf("ERROR: %v: Failed to save image: %v", s.label(), err)
+			icon = s.Icon
+		}
+	} else if s.Image != "" {
+		icon, err = s.image(ctx)
+		if err != nil {
+			log.Printf("ERROR: %v: Failed to load image for %v: %v", s.label(), topic, err)
+			icon = s.Icon
+		}
+	}
+	var badge string
+	if icon == "" && s.Badge != "" {
+		badge, err = s.templates.render("badge", s.Badge, ctx)
+		if err != nil {
+			log.Printf("ERROR: %v: Failed to render badge template: %v", s.label(), err)
+		}
+	}
+	if icon == "" && badge == "" {
+		icon = config.Icon
+	}
+
+	// in replace mode, each topic (or group) has at most one notification
+	var replaces, updates uint32
+	var suffix string
+	key := s.replaceKey(topic)
+	if s.replaces() {
+		replaces = getReplaceID(key)
+		if replaces != 0 && isDisplayed(notificationRef{serverOwner(s.Server), replaces}) {
+			updates = getUnread(key)
+		}
+		if s.ShowUnread {
+			count := getUnread(key) + 1
+			if count > 1 {
+				suffix = fmt.Sprintf(" (%d unread)", count)
+			}
+		}
+	}
+	// the unread count counts towards `max_title_len`
+	maxTitle := s.MaxTitleLen
+	if maxTitle > 0 && suffix != "" {
+		maxTitle -= len([]rune(suffix))
+		if maxTitle < 1 {
+			maxTitle = 1
+		}
+	}
+	title = truncate(title, maxTitle) + suffix
+
+	n := &Notification{
+		Title:    title,
+		Body:     body,
+		Icon:     icon,
+		Replaces: replaces,
+		Urgency:  s.Urgency,
+		Badge:    badge,
+	}
+	if computed != nil && computed.Urgency != "" {
+		n.Urgency = computed.Urgency
+	}
+	n.Position = s.Position
+	n.Server = s.Server
+	s.applySound(n, updates)
+	s.applyColors(n, ctx)
+	if s.Progress != nil {
+		s.applyProgress(n, ctx)
+	}
+	s.applyQuietHours(n, ctx.received)
+	if s.FeedbackTopic != "" {
+		// makes the notification clickable
+		n.addAction("default", "")
+	}
+
+	handlers := make(map[string]func())
+	if s.Copy != "" {
+		text, err := s.templates.render("copy", s.Copy, ctx)
+		if err != nil {
+			log.Printf("ERROR: %v: Failed to render copy template: %v", s.label(), err)
+		} else {
+			n.addAction("copy", "Copy")
+			handlers["copy"] = func() { copyToClipboard(text) }
+		}
+	}
+	if s.Open != "" {
+		path, err := s.templates.render("open", s.Open, ctx)
+		path = strings.TrimSpace(path)
+		if err == nil {
+			_, err = checkPath(path)
+		}
+		if err != nil {
+			log.Printf("ERROR: %v: Cannot open file: %v", s.label(), err)
+		} else {
+			n.addAction("default", "Open")
+			handlers["default"] = func() {
+				// checked again, the file may have been replaced with a link
+				// or allowed_dirs may have changed since the message arrived
+				resolved, err := checkPath(path)
+				if err != nil {
+					log.Printf("ERROR: %v: Cannot open file: %v", s.label(), err)
+					return
+				}
+				openFile(resolved)
+			}
+		}
+	}
+	if s.Link != "" {
+		link, err := s.templates.render("link", s.Link, ctx)
+		if err == nil {
+			link, err = checkLink(strings.TrimSpace(link))
+		}
+		if err != nil {
+			log.Printf("ERROR: %v: Cannot open link: %v", s.label(), err)
+		} else {
+			label := s.LinkLabel
+			if label == "" {
+				label = "Open link"
+			}
+			n.addAction("link", label)
+			handlers["link"] = func() { openFile(link) }
+		}
+	}
+	if s.AttachFull && truncated {
+		n.addAction("full", "Open full message")
+		handlers["full"] = func() {
+			path, err := saveFullMessage(ctx)
+			if err != nil {
+				log.Printf("ERROR: %v: Failed to save full message: %v", s.label(), err)
+				return
+			}
+			openFile(path)
+		}
+	}
+
+	return n, handlers, nil
+}
+
+// Send the notification for a message on `topic`
+// and register it for unread counts, actions, feedback and replacement.
+func (s *Subscription) deliver(topic string, n *Notification, handlers map[string]func()) {
+	config := s.config
+	// the next message must know the ID of this one to replace it
+	if config.AsyncNotify && !s.replaces() {
+		notifyAsync(n, func(id uint32, err error) {
+			if err != nil {
+				id, err = notifyWithRetry(n)
+			}
+			s.delivered(topic, handlers, id, err)
+		})
+		return
+	}
+
+	id, err := notifyWithRetry(n)
+	s.delivered(topic, handlers, id, err)
+}
+
+// Keep track of a notification which was sent (or failed).
+func (s *Subscription) delivered(topic string, handlers map[string]func(), id uint32, err error) {
+	if err != nil {
+		log.Printf("ERROR: %v: Failed to send notification: %v", s.label(), err)
+		s.count(statErrors)
+		emit(Event{Type: eventNotifyFailed, Topic: topic, Subscription: s, Error: err})
+		return
+	}
+
+	key := s.replaceKey(topic)
+	server := serverOwner(s.Server)
+	ref := notificationRef{server, id}
+	addUnread(key, ref)
+	setActions(ref, handlers)
+	emit(Event{Type: eventShown, Topic: topic, Subscription: s, ID: id, Server: server})
+	if s.replaces() {
+		setReplaceID(key, id)
+	}
+}
+
+// Whether notifications of this subscription replace previous ones.
+func (s *Subscription) replaces() bool {
+	return s.Replace || s.ReplaceGroup != "" || s.composite()
+}
+
+// The key for replace IDs and unread counts.
+// Subscriptions in the same replace group share one notification,
+// as do all topics of a composite subscription.
+func (s *Subscription) replaceKey(topic string) string {
+	if s.ReplaceGroup != "" {
+		return "group:" + s.ReplaceGroup
+	}
+	if s.composite() {
+		return "composite:" + s.filter()
+	}
+	return topic
+}
+
+// The numeric value of a message.
+// Either the payload itself or the `value_field` from a JSON payload.
+func (s *Subscription) value(ctx *TemplateContext) (float64, error) {
+	if s.ValueField == "" {
+		return toFloat(ctx.payload)
+	}
+
+	data, err := ctx.JSON()
+	if err != nil {
+		return 0, err
+	}
+	v, ok := lookupField(data, s.ValueField)
+	if !ok {
+		return 0, fmt.Errorf("No field %q in payload", s.ValueField)
+	}
+	return toFloat(v)
+}
+
+// Check whether the value of a message differs from the previous value
+// for the same topic and remember the value.
+// Compares the `value_field` from JSON messages or the complete payload.
+// A message without the `value_field` is not a change,
+// e.g. a device report which does not include the watched field.
+func (s *Subscription) changed(ctx *TemplateContext) bool {
+	value, err := s.textValue(ctx)
+	if _, missing := err.(missingFieldError); missing {
+		return false
+	} else if err != nil {
+		log.Printf("WARNING: Cannot compare value on %v: %v", ctx.topic, err)
+		return true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lastValues == nil {
+		s.lastValues = make(map[string]string)
+	}
+	previous, ok := s.lastValues[ctx.topic]
+	s.lastValues[ctx.topic] = value
+	return !ok || previous != value
+}
+
+// Decompress the payload with the given method ("gzip", "zlib" or "deflate").
+// An empty method returns the payload unchanged.
+func decompress(payload, method string) (string, error) {
+	var r io.ReadCloser
+	var err error
+	src := strings.NewReader(payload)
+
+	switch method {
+	case "":
+		return payload, nil
+	case "gzip":
+		r, err = gzip.NewReader(src)
+	case "zlib":
+		r, err = zlib.NewReader(src)
+	case "deflate":
+		r = flate.NewReader(src)
+	default:
+		return "", fmt.Errorf("Unknown compression %q", method)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// protect against "zip bombs"
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxPayloadSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxPayloadSize {
+		return "", errPayloadTooLarge
+	}
+	return string(data), nil
+}
+
+// Shorten the text to at most `max` characters, including an ellipsis.
+// Tries to cut at a word boundary. A `max` of 0 means no limit.
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if max <= 0 || len(runes) <= max {
+		return text
+	}
+	if max == 1 {
+		return ellipsis
+	}
+
+	cut := runes[:max-1]
+	// only cut at a word boundary if we don't lose too much text
+	for i := len(cut) - 1; i > len(cut)/2; i-- {
+		if unicode.IsSpace(cut[i]) {
+			cut = cut[:i]
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(cut), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + ellipsis
+}
+
+// Append the formatted receive time as a separate line to the body.
+func appendTimestamp(body string, t time.Time, format string) string {
+	if format == "" {
+		format = defaultTimestampFormat
+	}
+	stamp := t.Format(format)
+	if body == "" {
+		return stamp
+	}
+	return body + "\n" + stamp
+}
+
+// Create title and body for a notification.
+// Either from default (title=first line, body=subsequent lines)
+// or by filling the respective templates from configuration.
+func (s *Subscription) createTitleAndBody(ctx *TemplateContext) (string, string, error) {
+	config := s.config
+	title := ""
+	body := ""
+	useTemplates := (s.Title != "" || s.Body != "") && !ctx.fallback
+
+	if ctx.schemaError != nil {
+		return s.fillInvalidTemplates(ctx)
+	} else if useTemplates {
+		title, body, err := s.fillTemplates(ctx)
+		if err != nil && config.OnTemplateError == templateErrorRaw {
+			log.Printf("ERROR: %v: Failed to fill templates, showing the message: %v", s.label(), err)
+			s.count(statErrors)
+			title, body = splitPayload(ctx.payload)
+			return title, body, nil
+		}
+		return title, body, err
+	} else if ctx.summary != nil {
+		title = ctx.topic
+		body = ctx.summary.String()
+	} else if ctx.threshold != nil {
+		title = ctx.topic
+		body = ctx.threshold.String()
+	} else if ctx.pair != nil {
+		title = s.label()
+		body = ctx.pair.String()
+	} else if isImage(ctx.contentType) {
+		// the payload is displayed as icon
+		title = ctx.topic
+	} else {
+		title, body = splitPayload(ctx.payload)
+	}
+
+	return title, body, nil
+}
+
+// Use the first line of the payload as title and the rest as body.
+func splitPayload(payload string) (string, string) {
+	parts := strings.SplitN(payload, "\n", 2)
+	if len(parts) > 1 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// Fill the title and body templates.
+// The parsed templates are cached in `s.templates`, which has its own lock,
+// because aggregations are shown from a timer, next to incoming messages.
+func (s *Subscription) fillTemplates(ctx *TemplateContext) (string, string, error) {
+	title, err := s.templates.render(tplTitle, s.Title, ctx)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := s.templates.render(tplBody, s.Body, ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return title, body, nil
+}
+
+// Execute a template with the given context and return the result.
+func execute(tpl *template.Template, ctx *TemplateContext) (string, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+
+	err := tpl.Execute(buf, ctx)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Buffers for rendering templates, reused to reduce allocations
+// with many messages.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Parsed templates by name, for templates other than title and body.
+type templateCache struct {
+	templates map[string]*template.Template
+	mutex     sync.Mutex
+}
+
+// Fill the named template, parsing `raw` on first use.
+func (c *templateCache) render(name, raw string, ctx *TemplateContext) (string, error) {
+	c.mutex.Lock()
+	if c.templates == nil {
+		c.templates = make(map[string]*template.Template)
+	}
+	tpl, ok := c.templates[name]
+	if !ok {
+		var err error
+		tpl, err = template.New(name).Funcs(templateFuncs()).Parse(raw)
+		if err != nil {
+			c.mutex.Unlock()
+			return "", err
+		}
+		c.templates[name] = tpl
+	}
+	c.mutex.Unlock()
+
+	return execute(tpl, ctx)
+}
+
+type TemplateContext struct {
+	topic       string
+	payload     string
+	parts       []string
+	contentType string
+	decoded     interface{}
+	received    time.Time
+	summary     *Summary
+	threshold   *ThresholdEvent
+	values      map[string]string
+	timestamps  bool // convert timestamps in JSON to time values
+	stale       bool
+	fallback    bool  // render without templates
+	schemaError error // render the templates for invalid payloads
+	pair        *PairEvent
+	sub         *Subscription
+}
+
+func NewTemplateContext(topic, payload, contentType string) TemplateContext {
+	return TemplateContext{
+		topic:       topic,
+		payload:     payload,
+		parts:       strings.Split(topic, "/"),
+		contentType: contentType,
+		received:    time.Now(),
+	}
+}
+
+// The change of the threshold state, for subscriptions with `threshold`.
+func (t *TemplateContext) Threshold() (*ThresholdEvent, error) {
+	if t.threshold == nil {
+		return nil, errors.New("No threshold event, subscription has no threshold")
+	}
+	return t.threshold, nil
+}
+
+// The completed pair, for subscriptions with `pair`.
+func (t *TemplateContext) Pair() (*PairEvent, error) {
+	if t.pair == nil {
+		return nil, errors.New("No pair, subscription has no pair")
+	}
+	return t.pair, nil
+}
+
+// Summary of aggregated values, for subscriptions with `aggregate`.
+func (t *TemplateContext) Summary() (*Summary, error) {
+	if t.summary == nil {
+		return nil, errors.New("No summary, subscription does not aggregate")
+	}
+	return t.summary, nil
+}
+
+// Whether the message is a retained message within the `startup_grace`,
+// i.e. probably a state which was already known before.
+func (t *TemplateContext) Stale() bool {
+	return t.stale
+}
+
+// Why the payload does not match the schema,
+// in the templates for invalid payloads.
+func (t *TemplateContext) SchemaError() string {
+	if t.schemaError == nil {
+		return ""
+	}
+	return t.schemaError.Error()
+}
+
+// The content type of the payload, e.g. "application/json".
+func (t *TemplateContext) ContentType() string {
+	return t.contentType
+}
+
+// The payload decoded from JSON.
+func (t *TemplateContext) JSON() (interface{}, error) {
+	if t.decoded != nil {
+		return t.decoded, nil
+	}
+	if t.contentType != contentTypeJSON {
+		return nil, fmt.Errorf("Payload is %v, not JSON", t.contentType)
+	}
+
+	var err error
+	t.decoded, err = decodeJSON(t.payload)
+	if err == nil && t.timestamps {
+		parseTimestamps(t.decoded)
+	}
+	return t.decoded, err
+}
+
+// A segment of the topic, starting at 0.
+// Negative indexes count from the end, -1 is the last segment.
+func (t *TemplateContext) Topic(index int) (string, error) {
+	i, ok := t.topicIndex(index)
+	if !ok || i == len(t.parts) {
+		return "", errors.New("Invalid topic index")
+	}
+
+	return t.parts[i], nil
+}
+
+// The segments of the topic from `start` up to (excluding) `end`,
+// joined with "/". Negative indexes count from the end.
+func (t *TemplateContext) TopicRange(start, end int) (string, error) {
+	from, ok := t.topicIndex(start)
+	if !ok {
+		return "", errors.New("Invalid topic index")
+	}
+	to, ok := t.topicIndex(end)
+	if !ok || to < from {
+		return "", errors.New("Invalid topic index")
+	}
+
+	return strings.Join(t.parts[from:to], "/"), nil
+}
+
+// Resolve a possibly negative topic index.
+// Valid results are 0 to len(parts), inclusive.
+func (t *TemplateContext) topicIndex(index int) (int, bool) {
+	if index < 0 {
+		index += len(t.parts)
+	}
+	return index, index >= 0 && index <= len(t.parts)
+}
+
+// Hostname of this machine.
+func (t *TemplateContext) Hostname() (string, error) {
+	return os.Hostname()
+}
+
+// Login name of the current user.
+func (t *TemplateContext) User() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return currentUser.Username, nil
+}
+
+// Value of an environment variable.
+// Only variables listed in the `env` config option can be accessed.
+func (t *TemplateContext) Env(name string) (string, error) {
+	config := currentConfig()
+	for _, allowed := range config.Env {
+		if allowed == name {
+			return os.Getenv(name), nil
+		}
+	}
+	return "", fmt.Errorf("Environment variable %q is not allowed", name)
+}
+
+// Name, topic filter and `meta` values of the subscription.
+func (t *TemplateContext) Subscription() (*SubscriptionInfo, error) {
+	if t.sub == nil {
+		return nil, errors.New("No subscription")
+	}
+	return &SubscriptionInfo{
+		Name:  t.sub.Name,
+		Topic: t.sub.filter(),
+		Meta:  t.sub.Meta,
+	}, nil
+}
+
+// Metadata of a subscription, for templates.
+type SubscriptionInfo struct {
+	Name  string
+	Topic string
+	Meta  map[string]string
+}
+
+func (t *TemplateContext) String() string {
+	return t.payload
+}
+
+// Config ---------------------------------------------------------------------
+
+// Configuration options
+type Config struct {
+	Host               string                       `json:"host"`
+	Port               int                          `json:"port"`
+	Username           string                       `json:"username"`
+	Password           string                       `json:"password"`
+	Secure             bool                         `json:"secure"`
+	Transport          string                       `json:"transport"`
+	QoS                int                          `json:"qos"`
+	Path               string                       `json:"path"`
+	CAFile             string                       `json:"ca_file"`
+	CertFile           string                       `json:"cert_file"`
+	KeyFile            string                       `json:"key_file"`
+	KeyPassword        string                       `json:"key_password"`
+	Timeout            int                          `json:"timeout"`
+	Hello              bool                         `json:"hello"`
+	PausePolicy        string                       `json:"pause_policy"`
+	StartupGrace       int                          `json:"startup_grace"`
+	StalePolicy        string                       `json:"stale_policy"`
+	IdleTimeout        int                          `json:"idle_timeout"`
+	Socket             string                       `json:"socket"`
+	SessionUsers       []string                     `json:"session_users"`
+	DBusAddress        string                       `json:"dbus_address"`
+	ProbeInterval      int                          `json:"probe_interval"`
+	ErrorNotifications bool                         `json:"error_notifications"`
+	OnTemplateError    string                       `json:"on_template_error"`
+	ErrorSummary       bool                         `json:"error_summary"`
+	ErrorSummaryWindow int                          `json:"error_summary_window"`
+	NotifyDisconnect   bool                         `json:"notify_disconnect"`
+	Ntfy               []*NtfySource                `json:"ntfy"`
+	AllowedDirs        []string                     `json:"allowed_dirs"`
+	MaxVisible         int                          `json:"max_visible"`
+	Icon               string                       `json:"icon"`
+	IconTheme          string                       `json:"icon_theme"`
+	FallbackIcon       string                       `json:"fallback_icon"`
+	ImageCache         *ImageCacheConfig            `json:"image_cache"`
+	Locale             string                       `json:"locale"`
+	Maps               map[string]map[string]string `json:"maps"`
+	Env                []string                     `json:"env"`
+	Connection         ConnectionConfig             `json:"connection"`
+	Availability       *AvailabilityConfig          `json:"availability"`
+	ShutdownGrace      *int                         `json:"shutdown_grace"`
+	SubscribeTimeout   int                          `json:"subscribe_timeout"`
+	OrderMatters       *bool                        `json:"order_matters"`
+	QueueDepth         int                          `json:"queue_depth"`
+	NotifyRetries      *int                         `json:"notify_retries"`
+	AsyncNotify        bool                         `json:"async_notify"`
+	IgnoreRetained     bool                         `json:"ignore_retained"`
+	RetainedTopics     []string                     `json:"retained_topics"`
+	Hooks              map[string][]*Hook           `json:"hooks"`
+	TopicAllowlist     []string                     `json:"topic_allowlist"`
+	Presets            []*Preset                    `json:"presets"`
+	Schemas            map[string]string            `json:"schemas"`
+	Subscriptions      []*Subscription              `json:"subscriptions"`
+	payloadSchemas     map[string]map[string]interface{}
+}
 
-	path := filepath.Join(currentUser.HomeDir, ".config", APPNAME+".json")
-	f, err := os.Open(path)
+// Read configuration from the default path and make it the active one.
+func loadConfig() error {
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	setConfig(c)
+	return nil
+}
+
+// Get the active configuration.
+func currentConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return activeConfig
+}
+
+// Replace the active configuration.
+func setConfig(c *Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	activeConfig = c
+}
+
+// Read configuration from the default path.
+func readConfig() (*Config, error) {
+	// initialize with defaults
+	c := &Config{
+		Host:          "localhost",
+		Port:          1883,
+		Username:      "",
+		Password:      "",
+		Secure:        false,
+		Timeout:       5,
+		Icon:          "dialog-information",
+		PausePolicy:   policyDrop,
+		StalePolicy:   stalePolicyMark,
+		Connection:    defaultConnection(),
+		Socket:        defaultSocket,
+		Subscriptions: []*Subscription{},
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
 	if os.IsNotExist(err) {
 		log.Printf("No config file found at %v, using defaults", path)
-		return nil
+		return c, nil
 	} else if err != nil {
-		return err
+		return nil, err
+	}
+
+	data, err = decryptConfigFile(path, data)
+	if err != nil {
+		return nil, err
 	}
-	defer f.Close()
 
-	decoder := json.NewDecoder(f)
+	decoder := json.NewDecoder(bytes.NewReader(data))
 	for {
-		if err := decoder.Decode(&config); err == io.EOF {
+		if err := decoder.Decode(&c); err == io.EOF {
 			break
 		} else if err != nil {
-			return err
+			return nil, configError(path, data, err)
 		}
 	}
+	for _, option := range findUnknownOptions(data) {
+		line, column := position(data, option.Offset)
+		warnStartup("%v:%d:%d: unknown option %q is ignored",
+			path, line, column, option.Path)
+	}
+
+	err = c.applyPresets()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	err = c.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	err = c.loadSchemas(path)
+	if err != nil {
+		return nil, err
+	}
+	c.checkIcons()
+	c.expandTopics()
+
+	err = c.decryptSecrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range c.Subscriptions {
+		sub.config = c
+	}
+	return c, nil
+}
+
+// Replace the {hostname} and {user} placeholders in all topics,
+// so that one config file can be shared between machines.
+func (c *Config) expandTopics() {
+	replacements := []string{}
+	if hostname, err := os.Hostname(); err == nil {
+		replacements = append(replacements, "{hostname}", hostname)
+	}
+	if currentUser, err := user.Current(); err == nil {
+		replacements = append(replacements, "{user}", currentUser.Username)
+	}
+	r := strings.NewReplacer(replacements...)
 
+	expand := func(topics []string) {
+		for i := range topics {
+			topics[i] = r.Replace(topics[i])
+		}
+	}
+	expand(c.RetainedTopics)
+	expand(c.TopicAllowlist)
+	for _, hooks := range c.Hooks {
+		for _, hook := range hooks {
+			hook.Publish = r.Replace(hook.Publish)
+		}
+	}
+	if c.Availability != nil {
+		c.Availability.Topic = r.Replace(c.Availability.Topic)
+	}
+	for _, sub := range c.Subscriptions {
+		sub.Topic = r.Replace(sub.Topic)
+		sub.FeedbackTopic = r.Replace(sub.FeedbackTopic)
+		expand(sub.Topics)
+	}
+}
+
+// Timeout for subscribe and unsubscribe operations.
+// Defaults to the connect timeout.
+func (c *Config) subscribeTimeout() time.Duration {
+	if c.SubscribeTimeout > 0 {
+		return time.Duration(c.SubscribeTimeout) * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// Path to the configuration file.
+// In system mode, the configuration is read from /etc.
+func configPath() (string, error) {
+	if *systemMode {
+		return filepath.Join("/etc", APPNAME+".json"), nil
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(currentUser.HomeDir, ".config", APPNAME+".json"), nil
+}
+
+// Reload the configuration file and apply changed subscriptions.
+// Only topics which were added or removed are (un)subscribed,
+// unchanged topics keep their subscription.
+// Connection settings are not reloaded.
+func reloadConfig() error {
+	log.Println("Reload configuration...")
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	setConfig(c)
+	startIdleMonitor()
+	resubscribe()
+	emit(Event{Type: eventReloaded})
 	return nil
 }
+
+// Subscribe to the topics which are wanted now and unsubscribe from the rest,
+// e.g. after the configuration was reloaded.
+func resubscribe() {
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	config := currentConfig()
+	oldTopics := make(map[string]bool)
+	for _, topic := range subscribedTopics() {
+		oldTopics[topic] = true
+	}
+	newTopics := make(map[string]bool)
+	for _, topic := range config.topics() {
+		newTopics[topic] = true
+	}
+
+	remove := make([]string, 0)
+	for topic := range oldTopics {
+		if !newTopics[topic] {
+			remove = append(remove, topic)
+		}
+	}
+	add := make([]string, 0)
+	for topic := range newTopics {
+		if !oldTopics[topic] {
+			add = append(add, topic)
+		}
+	}
+
+	unsubscribeTopics(remove)
+	subscribeTopics(add)
+}
+
+// The QoS to subscribe to a topic with, the highest `qos` of the
+// subscriptions which use it or the default `qos` from config.
+func (c *Config) topicQoS(topic string) byte {
+	qos := c.QoS
+	for _, sub := range c.Subscriptions {
+		if sub.QoS == nil {
+			continue
+		}
+		uses := sub.Topic == topic
+		for _, t := range sub.Topics {
+			uses = uses || t == topic
+		}
+		if uses && *sub.QoS > qos {
+			qos = *sub.QoS
+		}
+	}
+	return byte(qos)
+}
+
+// All topics to subscribe to, without duplicates.
+func (c *Config) topics() []string {
+	seen := make(map[string]bool)
+	topics := make([]string, 0)
+	add := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	// topics which are only used for the `retained` template function
+	for _, topic := range c.RetainedTopics {
+		add(topic)
+	}
+	idle := isIdle()
+	for _, sub := range c.Subscriptions {
+		if sub.IdleUnsubscribe && idle {
+			continue
+		}
+		if sub.Topic == "" && !sub.composite() {
+			log.Println("WARNING: Ignoring subscription without topic.")
+			continue
+		}
+		if sub.Topic != "" && !isInternal(sub.Topic) {
+			add(sub.Topic)
+		}
+		for _, topic := range sub.Topics {
+			if !isInternal(topic) {
+				add(topic)
+			}
+		}
+	}
+	return topics
+}
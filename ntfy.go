@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ntfy -----------------------------------------------------------------------
+//
+// Messages from ntfy (https://ntfy.sh/) topics are fed into the same pipeline
+// as MQTT messages. A message on the ntfy topic "alerts" is dispatched
+// with the topic "ntfy/alerts".
+
+const ntfyPrefix = "ntfy/"
+const defaultNtfyServer = "https://ntfy.sh"
+
+// Configuration for a ntfy topic.
+type NtfySource struct {
+	Server   string `json:"server"`
+	Topic    string `json:"topic"`
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// A message from the ntfy JSON stream.
+type ntfyEvent struct {
+	ID      string `json:"id"`
+	Event   string `json:"event"`
+	Topic   string `json:"topic"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// Start streaming all configured ntfy topics.
+func startNtfy() {
+	config := currentConfig()
+	for _, source := range config.Ntfy {
+		if source.Topic == "" {
+			log.Println("WARNING: Ignoring ntfy source without topic.")
+			continue
+		}
+		go source.stream()
+	}
+}
+
+// Stream messages from the ntfy topic, reconnecting with increasing delays.
+func (n *NtfySource) stream() {
+	since := ""
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := n.read(&since)
+		log.Printf("ntfy stream for %v ended: %v", n.Topic, err)
+
+		// start over with a short delay if the stream was up for a while
+		if time.Since(started) > time.Minute {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+// Read the JSON stream until the connection is closed.
+// `since` is updated with the ID of the last message,
+// so no messages are lost when reconnecting.
+func (n *NtfySource) read(since *string) error {
+	server := n.Server
+	if server == "" {
+		server = defaultNtfyServer
+	}
+
+	u := strings.TrimRight(server, "/") + "/" + url.PathEscape(n.Topic) + "/json"
+	if *since != "" {
+		u += "?since=" + url.QueryEscape(*since)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	} else if n.Username != "" {
+		req.SetBasicAuth(n.Username, n.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %v", resp.Status)
+	}
+	log.Printf("Streaming ntfy topic %v from %v", n.Topic, server)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event ntfyEvent
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			log.Printf("WARNING: Invalid ntfy message: %v", err)
+			continue
+		}
+		if event.Event != "message" {
+			continue
+		}
+
+		*since = event.ID
+		payload := event.Message
+		if event.Title != "" {
+			payload = event.Title + "\n" + event.Message
+		}
+		enqueue(queuedMessage{Topic: ntfyPrefix + event.Topic, Payload: payload})
+	}
+
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	return errors.New("Connection closed")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// Observe Mode ---------------------------------------------------------------
+//
+// With -observe, the program connects to the broker and prints which
+// subscriptions match each incoming message and what they would show,
+// without showing anything. Useful to check new subscriptions
+// against real traffic.
+
+// Connect, subscribe and print incoming messages until interrupted.
+func runObserve() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	_, err := loadObserveConfig()
+	if err != nil {
+		return err
+	}
+
+	err = connectMQTT()
+	if err != nil {
+		return err
+	}
+	defer disconnectMQTT()
+	if mqttClient.IsConnected() {
+		subscribeOnce.Do(subscribe)
+	}
+	defer unsubscribe()
+
+	<-signals
+	return nil
+}
+
+// Load the configuration for observing, which needs a broker.
+func loadObserveConfig() (*Config, error) {
+	err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	config := currentConfig()
+	if config.Host == "" {
+		return nil, errors.New("No MQTT broker configured")
+	}
+	return config, nil
+}
+
+// Print what the subscriptions would do with a message.
+func observe(topic, payload string) {
+	fmt.Printf("%v %v %q\n", time.Now().Format("15:04:05"), topic, truncate(payload, 60))
+
+	config := currentConfig()
+	matches := config.matchingSubscriptions(topic)
+	if len(matches) == 0 {
+		fmt.Printf("    no matching subscription\n")
+	} else if !config.topicAllowed(topic) {
+		fmt.Printf("    dropped, topic not in allowlist\n")
+		return
+	}
+
+	for _, sub := range matches {
+		fmt.Printf("    %v: %v\n", sub.label(), observeResult(sub, topic, payload))
+		if sub.Stop {
+			break
+		}
+	}
+}
+
+// Describe what a subscription would do with a message.
+func observeResult(sub *Subscription, topic, payload string) string {
+	ctx, ok := sub.accept(topic, payload)
+	if !ok {
+		return "filtered"
+	}
+	if sub.clears(ctx) {
+		return "would close the notification"
+	}
+	if sub.Aggregate != nil {
+		return "aggregated"
+	}
+
+	n, _, err := sub.render(ctx)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	if n == nil {
+		return "filtered by template"
+	}
+
+	result := fmt.Sprintf("title %q", n.Title)
+	if n.Body != "" {
+		body := strings.Replace(n.Body, "\n", " ", -1)
+		result += fmt.Sprintf(", body %q", truncate(body, 60))
+	}
+	if n.Urgency != "" {
+		result += fmt.Sprintf(", urgency %v", n.Urgency)
+	}
+	if n.Replaces != 0 {
+		result += ", replaces"
+	}
+	return result
+}
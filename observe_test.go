@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+// Loads the configuration of the user running the test, or the defaults.
+func TestLoadObserveConfig(t *testing.T) {
+	previous := currentConfig()
+	setConfig(nil)
+	defer setConfig(previous)
+
+	config, err := loadObserveConfig()
+	if err != nil {
+		t.Skipf("No usable configuration: %v", err)
+	}
+	if config == nil || config != currentConfig() {
+		t.Fatalf("Expected the loaded configuration, got %v", config)
+	}
+	if config.Host == "" {
+		t.Errorf("Expected a broker")
+	}
+}
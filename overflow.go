@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Overflow -------------------------------------------------------------------
+//
+// If `max_visible` notifications are displayed, new notifications are
+// held back and a single summary notification tells how many are pending.
+// The "Show all" action on the summary shows the pending notifications.
+
+// Functions to show the pending notifications.
+var overflowQueue = make([]func(), 0)
+
+// The summary notification, ID 0 if there is none.
+var overflowID notificationRef
+var overflowMutex sync.Mutex
+
+// Held while the summary is sent, so that updates replace each other in order.
+// The summary is not sent under overflowMutex, which the signal handlers need.
+var overflowSendMutex sync.Mutex
+
+// Hold back a notification if too many notifications are displayed.
+// Returns true if the notification was held back.
+func holdOverflow(deliver func()) bool {
+	config := currentConfig()
+	if config.MaxVisible <= 0 || *systemMode {
+		return false
+	}
+
+	overflowSendMutex.Lock()
+	defer overflowSendMutex.Unlock()
+
+	overflowMutex.Lock()
+	if displayedCount() < config.MaxVisible && len(overflowQueue) == 0 {
+		overflowMutex.Unlock()
+		return false
+	}
+	overflowQueue = append(overflowQueue, deliver)
+	n := &Notification{
+		Title:    APPNAME,
+		Body:     fmt.Sprintf("%d pending MQTT alerts", len(overflowQueue)),
+		Icon:     config.Icon,
+		Replaces: overflowID.ID,
+		Actions:  []string{"default", "Show all", "expand", "Show all"},
+	}
+	overflowMutex.Unlock()
+
+	id, err := notify(n)
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+		return true
+	}
+	ref := notificationRef{serverOwner(""), id}
+
+	overflowMutex.Lock()
+	expanded := len(overflowQueue) == 0
+	if !expanded {
+		overflowID = ref
+	}
+	overflowMutex.Unlock()
+
+	// shown while the pending notifications were shown
+	if expanded {
+		server := dbusConn.Object(ref.Server, OBJ_PATH)
+		server.Call(DESTINATION+".CloseNotification", 0, ref.ID)
+		return true
+	}
+	setActions(ref, map[string]func(){
+		"default": expandOverflow,
+		"expand":  expandOverflow,
+	})
+	return true
+}
+
+// Show all pending notifications.
+func expandOverflow() {
+	overflowMutex.Lock()
+	queue := overflowQueue
+	overflowQueue = make([]func(), 0)
+	overflowID = notificationRef{}
+	overflowMutex.Unlock()
+
+	for _, deliver := range queue {
+		deliver()
+	}
+}
+
+// Called when a notification is closed.
+// If the user dismissed the summary, the pending notifications are dropped.
+func overflowClosed(id notificationRef, reason uint32) {
+	overflowMutex.Lock()
+	defer overflowMutex.Unlock()
+	if id.ID == 0 || id != overflowID {
+		return
+	}
+
+	overflowID = notificationRef{}
+	if reason == 2 {
+		log.Printf("Dropped %d pending notifications", len(overflowQueue))
+		overflowQueue = make([]func(), 0)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Pairs ----------------------------------------------------------------------
+//
+// A subscription with `pair` waits for a "start" message and shows a single
+// notification when the matching "end" message arrives, with the time
+// in between, e.g. "Washing machine finished, ran 1h 42m".
+// Start times are kept in the state file, so a pair survives a restart.
+
+// Configuration for start/end pairs.
+// Start and end are regular expressions for the value of a message,
+// optionally restricted to a topic filter.
+type PairConfig struct {
+	Start      string         `json:"start"`
+	End        string         `json:"end"`
+	StartTopic string         `json:"start_topic"`
+	EndTopic   string         `json:"end_topic"`
+	Key        string         `json:"key"` // template, to track several pairs
+	startRe    *regexp.Regexp `json:"-"`
+	endRe      *regexp.Regexp `json:"-"`
+}
+
+// A completed pair, available to templates as `.Pair`.
+type PairEvent struct {
+	Key      string
+	Started  time.Time
+	Ended    time.Time
+	Duration time.Duration
+}
+
+func (p *PairEvent) String() string {
+	d, _ := humanizeDuration(p.Duration)
+	return fmt.Sprintf("ran %v", d)
+}
+
+// Compile the start and end expressions.
+func (p *PairConfig) prepare() error {
+	if p.Start == "" {
+		return errors.New("start: required")
+	}
+	if p.End == "" {
+		return errors.New("end: required")
+	}
+
+	var err error
+	p.startRe, err = regexp.Compile(p.Start)
+	if err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	p.endRe, err = regexp.Compile(p.End)
+	if err != nil {
+		return fmt.Errorf("end: %v", err)
+	}
+	return nil
+}
+
+// Check a message for the start or end of a pair.
+// Returns true for the end of a pair, with `ctx.pair` set.
+// All other messages produce no notification.
+func (s *Subscription) checkPair(ctx *TemplateContext) bool {
+	p := s.Pair
+	value, err := s.textValue(ctx)
+	if err != nil {
+		log.Printf("WARNING: Cannot check pair on %v: %v", ctx.topic, err)
+		return false
+	}
+	value = strings.TrimSpace(value)
+
+	key := ctx.topic
+	if p.StartTopic != "" || p.EndTopic != "" {
+		// start and end come from different topics
+		key = ""
+	}
+	if p.Key != "" {
+		key, err = s.templates.render("pair", p.Key, ctx)
+		if err != nil {
+			log.Printf("ERROR: Failed to render pair key: %v", err)
+			return false
+		}
+	}
+	key = s.label() + ":" + strings.TrimSpace(key)
+
+	now := ctx.received
+	if (p.EndTopic == "" || topicMatches(p.EndTopic, ctx.topic)) && p.endRe.MatchString(value) {
+		started, ok := endPair(key)
+		if !ok {
+			return false
+		}
+		ctx.pair = &PairEvent{
+			Key:      key,
+			Started:  started,
+			Ended:    now,
+			Duration: now.Sub(started),
+		}
+		return true
+	}
+	if (p.StartTopic == "" || topicMatches(p.StartTopic, ctx.topic)) && p.startRe.MatchString(value) {
+		startPair(key, now)
+	}
+	return false
+}
+
+// Remember the start of a pair, unless it has already started.
+func startPair(key string, t time.Time) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if _, ok := state.PairStarts[key]; ok {
+		return
+	}
+	state.PairStarts[key] = t
+	err := saveState()
+	if err != nil {
+		log.Printf("WARNING: Failed to save state: %v", err)
+	}
+}
+
+// Forget the start of a pair and return it.
+func endPair(key string) (time.Time, bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	started, ok := state.PairStarts[key]
+	if !ok {
+		return started, false
+	}
+	delete(state.PairStarts, key)
+	err := saveState()
+	if err != nil {
+		log.Printf("WARNING: Failed to save state: %v", err)
+	}
+	return started, true
+}
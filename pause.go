@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Pause ----------------------------------------------------------------------
+
+const policyDrop = "drop"
+const policyQueue = "queue"
+const maxQueued = 1000
+
+// A message which waits to be processed.
+type queuedMessage struct {
+	Topic    string
+	Payload  string
+	Retained bool
+	Stale    bool // retained message within the `startup_grace`
+}
+
+var paused = false
+var pausedQueue = make([]queuedMessage, 0)
+var pausedDropped = 0
+var pauseMutex sync.Mutex
+
+// Stop showing notifications until `resume` is called.
+func pause() {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	if !paused {
+		paused = true
+		log.Println("Paused notifications")
+	}
+}
+
+// Show notifications again.
+// Messages which were queued while paused are dispatched now.
+// For dropped messages, a single notification tells how many there were.
+func resume() {
+	config := currentConfig()
+	pauseMutex.Lock()
+	if !paused {
+		pauseMutex.Unlock()
+		return
+	}
+	paused = false
+	queue := pausedQueue
+	dropped := pausedDropped
+	pausedQueue = make([]queuedMessage, 0)
+	pausedDropped = 0
+	pauseMutex.Unlock()
+
+	log.Printf("Resumed notifications, %d queued, %d dropped", len(queue), dropped)
+	for _, m := range queue {
+		enqueue(m)
+	}
+
+	if dropped > 0 {
+		_, err := notify(&Notification{
+			Title: APPNAME,
+			Body:  fmt.Sprintf("%d messages while paused", dropped),
+			Icon:  config.Icon,
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to send notification: %v", err)
+		}
+	}
+}
+
+// Whether notifications are paused.
+func isPaused() bool {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	return paused
+}
+
+// Keep a message for later if notifications are paused,
+// according to the `pause_policy`.
+// Returns true if the message was held back.
+func holdMessage(m queuedMessage) bool {
+	config := currentConfig()
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	if !paused {
+		return false
+	}
+
+	if config.PausePolicy == policyQueue && len(pausedQueue) < maxQueued {
+		pausedQueue = append(pausedQueue, m)
+	} else {
+		pausedDropped++
+	}
+	return true
+}
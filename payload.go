@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Payload Decoding -----------------------------------------------------------
+
+const contentTypeJSON = "application/json"
+const contentTypeText = "text/plain"
+
+// Not decoded, rejected as `content_type`.
+const contentTypeCBOR = "application/cbor"
+
+// Guess the content type of a payload.
+//
+// MQTT 3.1.1 messages carry no content type property,
+// so the type is detected from the payload itself.
+// The MQTT 5 property cannot be used, the client speaks only MQTT 3.1.1.
+func detectContentType(payload string) string {
+	trimmed := strings.TrimSpace(payload)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return contentTypeJSON
+		}
+	}
+	return baseContentType(http.DetectContentType([]byte(payload)))
+}
+
+// Strip parameters like "; charset=utf-8" from a content type.
+func baseContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// Whether the content type denotes an image.
+func isImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// Decode a JSON payload.
+func decodeJSON(payload string) (interface{}, error) {
+	var data interface{}
+	err := json.Unmarshal([]byte(payload), &data)
+	return data, err
+}
+
+// Names of JSON fields which usually hold a timestamp.
+var timestampFields = map[string]bool{
+	"time":      true,
+	"ts":        true,
+	"timestamp": true,
+}
+
+// Replace timestamps in decoded JSON data with time values, at any depth.
+// Accepts Unix timestamps in seconds or milliseconds and RFC 3339 strings.
+// Values which are not timestamps are kept as they are.
+func parseTimestamps(data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if !timestampFields[strings.ToLower(key)] {
+				parseTimestamps(value)
+				continue
+			}
+			if n, ok := value.(float64); ok && n > 1e12 {
+				value = n / 1000 // milliseconds
+			}
+			if t, err := toTime(value); err == nil {
+				v[key] = t
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			parseTimestamps(item)
+		}
+	}
+}
+
+// Get a field from decoded JSON data by its path.
+// The path is a dot separated list of object keys or array indices,
+// e.g. "sensors.0.temperature".
+func lookupField(data interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := data.(type) {
+		case map[string]interface{}:
+			value, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			data = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			data = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// Ways to decode topic segments.
+const (
+	topicDecodingURL  = "url"  // percent-encoded, e.g. "Living%20Room"
+	topicDecodingUTF8 = "utf8" // replace invalid UTF-8 sequences
+)
+
+// Decode the segments of a topic for use in templates.
+// Segments which cannot be decoded are kept as they are.
+func decodeTopicParts(parts []string, method string) []string {
+	if method == "" {
+		return parts
+	}
+	decoded := make([]string, len(parts))
+	for i, part := range parts {
+		decoded[i] = part
+		switch method {
+		case topicDecodingURL:
+			if unescaped, err := url.PathUnescape(part); err == nil {
+				decoded[i] = strings.ToValidUTF8(unescaped, "\uFFFD")
+			}
+		case topicDecodingUTF8:
+			decoded[i] = strings.ToValidUTF8(part, "\uFFFD")
+		}
+	}
+	return decoded
+}
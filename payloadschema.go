@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Payload Schemas ------------------------------------------------------------
+//
+// JSON payloads can be checked against a JSON Schema before they reach the
+// templates, so that a change in the format of a device shows up as a clear
+// error. Schemas are files, registered by name in the `schemas` option.
+//
+// Only a subset of JSON Schema is supported: type, enum, const, required,
+// properties, additionalProperties, items, minItems, maxItems, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength
+// and pattern.
+//
+// Patterns are compiled when the schema is loaded and replace the pattern
+// strings in the decoded schema, so that an invalid pattern is reported
+// at startup and not for every message.
+
+// What happens to a payload which does not match its schema.
+const invalidDrop = "drop"
+const invalidRaw = "raw"
+const invalidNotify = "notify"
+const invalidFallback = "fallback"
+
+// Names of the templates for payloads which do not match their schema.
+const tplInvalidTitle = "invalid_title"
+const tplInvalidBody = "invalid_body"
+
+// Read the schema files from the `schemas` option.
+// Relative paths are relative to the configuration file.
+func (c *Config) loadSchemas(configFile string) error {
+	c.payloadSchemas = make(map[string]map[string]interface{}, len(c.Schemas))
+	for name, path := range c.Schemas {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("schemas.%v: %v", name, err)
+		}
+		var schema map[string]interface{}
+		err = json.Unmarshal(data, &schema)
+		if err == nil {
+			err = compilePatterns(schema, "")
+		}
+		if err != nil {
+			return fmt.Errorf("schemas.%v: %v: %v", name, path, err)
+		}
+		c.payloadSchemas[name] = schema
+	}
+
+	valid := make([]*Subscription, 0, len(c.Subscriptions))
+	for _, sub := range c.Subscriptions {
+		if sub.Schema != "" && c.payloadSchemas[sub.Schema] == nil {
+			warnStartup("%v: unknown schema %q, subscription is disabled",
+				sub.label(), sub.Schema)
+			continue
+		}
+		valid = append(valid, sub)
+	}
+	c.Subscriptions = valid
+	return nil
+}
+
+// Check the payload against the subscription's schema.
+// Returns false if the message should be dropped.
+func (s *Subscription) checkSchema(ctx *TemplateContext) bool {
+	config := s.config
+	data, err := decodeJSON(ctx.payload)
+	if err == nil {
+		err = validatePayload(config.payloadSchemas[s.Schema], data, "$")
+	}
+	if err == nil {
+		return true
+	}
+
+	log.Printf("ERROR: %v: Payload on %v does not match schema %q: %v",
+		s.label(), ctx.topic, s.Schema, err)
+	s.count(statErrors)
+	switch s.OnInvalid {
+	case invalidRaw:
+		ctx.fallback = true
+		return true
+	case invalidFallback:
+		ctx.schemaError = err
+		return true
+	case invalidNotify:
+		notifyError(s, err)
+	}
+	return false
+}
+
+// Render the `invalid_title` and `invalid_body` templates
+// for a payload which does not match the schema.
+func (s *Subscription) fillInvalidTemplates(ctx *TemplateContext) (string, string, error) {
+	title, err := s.templates.render(tplInvalidTitle, s.InvalidTitle, ctx)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := s.templates.render(tplInvalidBody, s.InvalidBody, ctx)
+	return title, body, err
+}
+
+// Replace the `pattern` strings in a schema with compiled expressions,
+// in the schema itself and in the schemas for properties and items.
+func compilePatterns(schema map[string]interface{}, path string) error {
+	if raw, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			return fmt.Errorf("%vpattern: %v", path, err)
+		}
+		schema["pattern"] = re
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for key, sub := range properties {
+		if sub, ok := sub.(map[string]interface{}); ok {
+			err := compilePatterns(sub, path+"properties."+key+".")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"additionalProperties", "items"} {
+		if sub, ok := schema[keyword].(map[string]interface{}); ok {
+			err := compilePatterns(sub, path+keyword+".")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate decoded JSON data against a schema.
+// Returns an error for the first violation, with the path to the value.
+func validatePayload(schema map[string]interface{}, data interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesType(t, data) {
+		return fmt.Errorf("%v: expected %v, got %v", path, t, jsonType(data))
+	}
+	if values, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range values {
+			found = found || jsonEqual(v, data)
+		}
+		if !found {
+			return fmt.Errorf("%v: %v is not one of %v", path, data, values)
+		}
+	}
+	if v, ok := schema["const"]; ok && !jsonEqual(v, data) {
+		return fmt.Errorf("%v: expected %v, got %v", path, v, data)
+	}
+
+	switch value := data.(type) {
+	case map[string]interface{}:
+		return validateObject(schema, value, path)
+	case []interface{}:
+		return validateArray(schema, value, path)
+	case string:
+		return validateString(schema, value, path)
+	case float64:
+		return validateNumber(schema, value, path)
+	}
+	return nil
+}
+
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string) error {
+	required, _ := schema["required"].([]interface{})
+	for _, key := range required {
+		name, _ := key.(string)
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%v: missing %q", path, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // report errors in a stable order
+
+	for _, key := range keys {
+		sub, ok := properties[key].(map[string]interface{})
+		if !ok {
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%v: unexpected %q", path, key)
+				}
+			case map[string]interface{}:
+				sub = additional
+			}
+		}
+		if sub == nil {
+			continue
+		}
+		err := validatePayload(sub, obj[key], path+"."+key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateArray(schema map[string]interface{}, items []interface{}, path string) error {
+	if n, ok := schema["minItems"].(float64); ok && float64(len(items)) < n {
+		return fmt.Errorf("%v: expected at least %v items", path, n)
+	}
+	if n, ok := schema["maxItems"].(float64); ok && float64(len(items)) > n {
+		return fmt.Errorf("%v: expected at most %v items", path, n)
+	}
+	sub, ok := schema["items"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for i, item := range items {
+		err := validatePayload(sub, item, fmt.Sprintf("%v[%d]", path, i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateString(schema map[string]interface{}, s string, path string) error {
+	length := float64(len([]rune(s)))
+	if n, ok := schema["minLength"].(float64); ok && length < n {
+		return fmt.Errorf("%v: expected at least %v characters", path, n)
+	}
+	if n, ok := schema["maxLength"].(float64); ok && length > n {
+		return fmt.Errorf("%v: expected at most %v characters", path, n)
+	}
+	if re, ok := schema["pattern"].(*regexp.Regexp); ok && !re.MatchString(s) {
+		return fmt.Errorf("%v: %q does not match %q", path, s, re)
+	}
+	return nil
+}
+
+func validateNumber(schema map[string]interface{}, f float64, path string) error {
+	if n, ok := schema["minimum"].(float64); ok && f < n {
+		return fmt.Errorf("%v: %v is less than %v", path, f, n)
+	}
+	if n, ok := schema["maximum"].(float64); ok && f > n {
+		return fmt.Errorf("%v: %v is greater than %v", path, f, n)
+	}
+	if n, ok := schema["exclusiveMinimum"].(float64); ok && f <= n {
+		return fmt.Errorf("%v: %v is not greater than %v", path, f, n)
+	}
+	if n, ok := schema["exclusiveMaximum"].(float64); ok && f >= n {
+		return fmt.Errorf("%v: %v is not less than %v", path, f, n)
+	}
+	return nil
+}
+
+// Whether the data has the type, or one of the types, from a schema.
+func matchesType(t interface{}, data interface{}) bool {
+	actual := jsonType(data)
+	switch expected := t.(type) {
+	case string:
+		return typeMatches(expected, actual, data)
+	case []interface{}:
+		for _, e := range expected {
+			name, _ := e.(string)
+			if typeMatches(name, actual, data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeMatches(expected, actual string, data interface{}) bool {
+	if expected == "integer" {
+		f, ok := data.(float64)
+		return ok && f == math.Trunc(f)
+	}
+	return expected == actual
+}
+
+// The JSON Schema type name of decoded JSON data.
+func jsonType(data interface{}) string {
+	switch data.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return strings.ToLower(fmt.Sprintf("%T", data))
+}
+
+// Compare two decoded JSON values.
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
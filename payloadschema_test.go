@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidatePayload(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		payload string
+		err     string // part of the expected error, "" if valid
+	}{
+		{"type", `{"type": "object"}`, `{}`, ""},
+		{"type mismatch", `{"type": "object"}`, `[]`, "$: expected object, got array"},
+		{"type list", `{"type": ["string", "null"]}`, `null`, ""},
+		{"integer", `{"type": "integer"}`, `3`, ""},
+		{"not integer", `{"type": "integer"}`, `3.5`, "expected integer"},
+		{"required", `{"required": ["state"]}`, `{"state": "ON"}`, ""},
+		{"required missing", `{"required": ["state"]}`, `{}`, `$: missing "state"`},
+		{"enum", `{"enum": ["ON", "OFF"]}`, `"ON"`, ""},
+		{"enum mismatch", `{"enum": ["ON", "OFF"]}`, `"DIM"`, "is not one of"},
+		{"const", `{"const": 1}`, `1`, ""},
+		{"const mismatch", `{"const": 1}`, `2`, "expected 1, got 2"},
+		{"pattern", `{"pattern": "^0x[0-9a-f]+$"}`, `"0x1f"`, ""},
+		{"pattern mismatch", `{"pattern": "^0x[0-9a-f]+$"}`, `"1f"`, `"1f" does not match "^0x[0-9a-f]+$"`},
+		{"minimum", `{"minimum": 0}`, `0`, ""},
+		{"below minimum", `{"minimum": 0}`, `-1`, "-1 is less than 0"},
+		{"above maximum", `{"maximum": 100}`, `101`, "101 is greater than 100"},
+		{"exclusive minimum", `{"exclusiveMinimum": 0}`, `0`, "is not greater than 0"},
+		{"exclusive maximum", `{"exclusiveMaximum": 1}`, `1`, "is not less than 1"},
+		{"min length", `{"minLength": 2}`, `"ä"`, "at least 2 characters"},
+		{"max items", `{"maxItems": 1}`, `[1, 2]`, "at most 1 items"},
+		{"nested", `{"properties": {"update": {"properties": {"state": {"enum": ["idle", "available"]}}}}}`,
+			`{"update": {"state": "idle"}}`, ""},
+		{"nested mismatch", `{"properties": {"update": {"properties": {"state": {"enum": ["idle", "available"]}}}}}`,
+			`{"update": {"state": "busy"}}`, "$.update.state:"},
+		{"nested pattern", `{"properties": {"id": {"pattern": "^[a-z]+$"}}}`, `{"id": "Abc"}`, "$.id:"},
+		{"items", `{"items": {"type": "number"}}`, `[1, "2"]`, "$[1]: expected number"},
+		{"additional properties", `{"properties": {"a": {}}, "additionalProperties": false}`,
+			`{"a": 1, "b": 2}`, `$: unexpected "b"`},
+		{"additional properties schema", `{"additionalProperties": {"type": "string"}}`,
+			`{"a": 1}`, "$.a: expected string"},
+	}
+
+	for _, c := range cases {
+		schema := parseTestSchema(t, c.schema)
+		var data interface{}
+		err := json.Unmarshal([]byte(c.payload), &data)
+		if err != nil {
+			t.Fatalf("%v: invalid payload: %v", c.name, err)
+		}
+
+		err = validatePayload(schema, data, "$")
+		if c.err == "" && err != nil {
+			t.Errorf("%v: unexpected error: %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%v: expected error with %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestCompilePatterns(t *testing.T) {
+	cases := []struct {
+		schema string
+		err    string
+	}{
+		{`{"pattern": "^a+$"}`, ""},
+		{`{"pattern": "(a"}`, "pattern:"},
+		{`{"properties": {"id": {"pattern": "[a"}}}`, "properties.id.pattern:"},
+		{`{"items": {"pattern": "*"}}`, "items.pattern:"},
+		{`{"additionalProperties": {"pattern": "("}}`, "additionalProperties.pattern:"},
+	}
+
+	for _, c := range cases {
+		var schema map[string]interface{}
+		err := json.Unmarshal([]byte(c.schema), &schema)
+		if err != nil {
+			t.Fatalf("%v: invalid schema: %v", c.schema, err)
+		}
+
+		err = compilePatterns(schema, "")
+		if c.err == "" && err != nil {
+			t.Errorf("%v: unexpected error: %v", c.schema, err)
+		} else if c.err != "" && (err == nil || !strings.HasPrefix(err.Error(), c.err)) {
+			t.Errorf("%v: expected error starting with %q, got %v", c.schema, c.err, err)
+		}
+	}
+}
+
+func parseTestSchema(t *testing.T, raw string) map[string]interface{} {
+	var schema map[string]interface{}
+	err := json.Unmarshal([]byte(raw), &schema)
+	if err == nil {
+		err = compilePatterns(schema, "")
+	}
+	if err != nil {
+		t.Fatalf("invalid schema %v: %v", raw, err)
+	}
+	return schema
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Power Conditions -----------------------------------------------------------
+//
+// Subscriptions can be suppressed while running on battery,
+// based on UPower on the system bus.
+
+const (
+	upowerName    = "org.freedesktop.UPower"
+	upowerPath    = dbus.ObjectPath("/org/freedesktop/UPower")
+	upowerDisplay = dbus.ObjectPath("/org/freedesktop/UPower/devices/DisplayDevice")
+)
+
+// How long the power state is cached.
+const powerCacheTime = 30 * time.Second
+
+// Conditions for the power supply.
+type PowerCondition struct {
+	SkipOnBattery bool    `json:"skip_on_battery"` // suppress on battery
+	MinBattery    float64 `json:"min_battery"`     // suppress on battery below this percentage
+}
+
+// The power state from UPower.
+type powerState struct {
+	onBattery  bool
+	percentage float64
+	checked    time.Time
+}
+
+var power powerState
+var powerMutex sync.Mutex
+var powerWarned bool
+
+// Get the current power state, cached for `powerCacheTime`.
+// Without UPower, we assume to be on AC power.
+func currentPower() powerState {
+	powerMutex.Lock()
+	defer powerMutex.Unlock()
+	if time.Since(power.checked) < powerCacheTime {
+		return power
+	}
+
+	state, err := queryUPower()
+	if err != nil {
+		if !powerWarned {
+			log.Printf("WARNING: Cannot get power state from UPower: %v", err)
+			powerWarned = true
+		}
+		state = powerState{}
+	}
+	state.checked = time.Now()
+	power = state
+	return power
+}
+
+// Read the power state from UPower.
+func queryUPower() (powerState, error) {
+	var state powerState
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return state, err
+	}
+
+	v, err := conn.Object(upowerName, upowerPath).GetProperty(upowerName + ".OnBattery")
+	if err != nil {
+		return state, err
+	}
+	state.onBattery, _ = v.Value().(bool)
+
+	v, err = conn.Object(upowerName, upowerDisplay).GetProperty(upowerName + ".Device.Percentage")
+	if err != nil {
+		return state, err
+	}
+	state.percentage, _ = v.Value().(float64)
+	return state, nil
+}
+
+// Whether the power conditions of the subscription allow a notification.
+func (s *Subscription) powerAllows() bool {
+	c := s.Power
+	if c == nil || (!c.SkipOnBattery && c.MinBattery <= 0) {
+		return true
+	}
+
+	state := currentPower()
+	if !state.onBattery {
+		return true
+	}
+	if c.SkipOnBattery {
+		return false
+	}
+	return state.percentage >= c.MinBattery
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Presets --------------------------------------------------------------------
+//
+// Presets are built-in subscriptions for common MQTT integrations.
+// They are enabled by name in the `presets` config option
+// and added after the configured subscriptions.
+// Their title templates render to an empty string for messages which
+// should not be shown, so preset subscriptions drop those messages.
+// Topic segments are counted from the end, because a custom base topic
+// may have more than one segment.
+
+// A preset enabled in the config.
+type Preset struct {
+	Name      string `json:"name"`
+	BaseTopic string `json:"base_topic"`
+	URL       string `json:"url"`
+}
+
+// Definition of a built-in preset.
+// The subscriptions are JSON, like in the config file,
+// where "{base}" is replaced with the base topic
+// and "{url}" with the URL of the service (if it needs one).
+type presetDefinition struct {
+	baseTopic     string
+	needsURL      bool
+	subscriptions string
+}
+
+var presetDefinitions = map[string]presetDefinition{
+	"zigbee2mqtt": {
+		baseTopic: "zigbee2mqtt",
+		subscriptions: `[
+			{
+				"topic": "{base}/bridge/event",
+				"title": "{{if eq .JSON.type \"device_joined\"}}New Zigbee device{{else if eq .JSON.type \"device_leave\"}}Zigbee device removed{{end}}",
+				"body": "{{.JSON.data.friendly_name}}",
+				"icon": "network-wireless"
+			},
+			{
+				"topic": "{base}/+/availability",
+				"value_field": "state",
+				"on_change": true,
+				"replace": true,
+				"title": "{{if eq (print .JSON.state) \"offline\"}}{{.Topic -2}} is offline{{end}}",
+				"body": "Zigbee device not available",
+				"icon": "network-wireless-disconnected"
+			},
+			{
+				"topic": "{base}/+",
+				"value_field": "update.state",
+				"on_change": true,
+				"title": "{{with .JSON.update}}{{if eq (print .state) \"available\"}}Update for {{$.Topic -1}}{{end}}{{end}}",
+				"body": "A firmware update is available",
+				"icon": "software-update-available"
+			}
+		]`,
+	},
+	"tasmota": {
+		baseTopic: "tele",
+		subscriptions: `[
+			{
+				"topic": "{base}/+/LWT",
+				"on_change": true,
+				"replace": true,
+				"title": "{{if eq .String \"Offline\"}}{{.Topic -2}} is offline{{end}}",
+				"body": "Tasmota device not available",
+				"icon": "network-offline"
+			},
+			{
+				"topic": "{base}/+/STATE",
+				"value_field": "Wifi.RSSI",
+				"threshold": {"below": 30, "below_clear": 40},
+				"replace": true,
+				"title": "{{if eq .Threshold.State \"below\"}}Weak WiFi signal on {{.Topic -2}}{{end}}",
+				"body": "Signal quality {{.JSON.Wifi.RSSI}}%",
+				"icon": "network-wireless-signal-weak"
+			}
+		]`,
+	},
+	"frigate": {
+		baseTopic: "frigate",
+		needsURL:  true,
+		subscriptions: `[
+			{
+				"topic": "{base}/events",
+				"title": "{{with .JSON}}{{if eq .type \"new\"}}{{.after.label}} on {{.after.camera}}{{end}}{{end}}",
+				"body": "Score {{.JSON.after.top_score | number 2}}",
+				"image": "{url}/api/events/{{.JSON.after.id}}/snapshot.jpg",
+				"link": "{url}/api/events/{{.JSON.after.id}}/clip.mp4",
+				"link_label": "Open clip",
+				"icon": "camera-web"
+			}
+		]`,
+	},
+	"esphome": {
+		baseTopic: "+",
+		subscriptions: `[
+			{
+				"topic": "{base}/status",
+				"on_change": true,
+				"replace": true,
+				"title": "{{if eq .String \"offline\"}}{{.Topic -2}} is offline{{end}}",
+				"body": "ESPHome device not available",
+				"icon": "network-offline"
+			}
+		]`,
+	},
+}
+
+// Add the subscriptions from the enabled presets.
+func (c *Config) applyPresets() error {
+	for i, preset := range c.Presets {
+		def, ok := presetDefinitions[preset.Name]
+		if !ok {
+			return fmt.Errorf("presets[%d]: unknown preset %q, expected one of %v",
+				i, preset.Name, presetNames())
+		}
+
+		base := def.baseTopic
+		if preset.BaseTopic != "" {
+			base = strings.TrimSuffix(preset.BaseTopic, "/")
+		}
+		if def.needsURL && preset.URL == "" {
+			return fmt.Errorf("presets[%d]: preset %v needs a url", i, preset.Name)
+		}
+		raw := strings.NewReplacer(
+			"{base}", base,
+			"{url}", strings.TrimSuffix(preset.URL, "/"),
+		).Replace(def.subscriptions)
+
+		var subscriptions []*Subscription
+		err := json.Unmarshal([]byte(raw), &subscriptions)
+		if err != nil {
+			return fmt.Errorf("Invalid preset %v: %v", preset.Name, err)
+		}
+		for _, sub := range subscriptions {
+			sub.preset = preset.Name
+		}
+		c.Subscriptions = append(c.Subscriptions, subscriptions...)
+	}
+	return nil
+}
+
+// Names of the built-in presets.
+func presetNames() []string {
+	names := make([]string, 0, len(presetDefinitions))
+	for name := range presetDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestPresetTopics(t *testing.T) {
+	cases := []struct {
+		preset   Preset
+		filter   string // topic of the preset subscription
+		topic    string
+		payload  string
+		expected string
+	}{
+		{Preset{Name: "zigbee2mqtt"}, "zigbee2mqtt/+/availability",
+			"zigbee2mqtt/door/availability", `{"state": "offline"}`, "door is offline"},
+		{Preset{Name: "zigbee2mqtt", BaseTopic: "z2m/home"}, "z2m/home/+/availability",
+			"z2m/home/door/availability", `{"state": "offline"}`, "door is offline"},
+		{Preset{Name: "zigbee2mqtt", BaseTopic: "z2m/home"}, "z2m/home/+/availability",
+			"z2m/home/door/availability", `{"state": "online"}`, ""},
+		{Preset{Name: "zigbee2mqtt"}, "zigbee2mqtt/+",
+			"zigbee2mqtt/lamp", `{"update": {"state": "available"}}`, "Update for lamp"},
+		{Preset{Name: "zigbee2mqtt", BaseTopic: "z2m/home/"}, "z2m/home/+",
+			"z2m/home/lamp", `{"update": {"state": "available"}}`, "Update for lamp"},
+		{Preset{Name: "tasmota"}, "tele/+/LWT",
+			"tele/plug/LWT", "Offline", "plug is offline"},
+		{Preset{Name: "tasmota", BaseTopic: "tele/home"}, "tele/home/+/LWT",
+			"tele/home/plug/LWT", "Offline", "plug is offline"},
+		{Preset{Name: "tasmota", BaseTopic: "tele/home"}, "tele/home/+/LWT",
+			"tele/home/plug/LWT", "Online", ""},
+	}
+
+	for _, c := range cases {
+		config := &Config{Presets: []*Preset{&c.preset}}
+		err := config.applyPresets()
+		if err != nil {
+			t.Fatalf("%v: %v", c.preset.Name, err)
+		}
+
+		var sub *Subscription
+		for _, s := range config.Subscriptions {
+			if s.Topic == c.filter {
+				sub = s
+			}
+		}
+		if sub == nil {
+			t.Errorf("%v: no subscription for %v", c.preset.Name, c.filter)
+			continue
+		}
+
+		ctx := NewTemplateContext(c.topic, c.payload, detectContentType(c.payload))
+		title, err := sub.templates.render(tplTitle, sub.Title, &ctx)
+		if err != nil {
+			t.Errorf("%v: %v: %v", c.preset.Name, c.topic, err)
+		} else if title != c.expected {
+			t.Errorf("%v: %v: expected %q, got %q", c.preset.Name, c.topic, c.expected, title)
+		}
+	}
+}
+
+// Ordinary device reports on the zigbee2mqtt device topic
+// are skipped quietly, only update messages are shown.
+func TestPresetDeviceReports(t *testing.T) {
+	config := &Config{Presets: []*Preset{{Name: "zigbee2mqtt"}}}
+	err := config.applyPresets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sub *Subscription
+	for _, s := range config.Subscriptions {
+		if s.Topic == "zigbee2mqtt/+" {
+			sub = s
+		}
+	}
+	if sub == nil {
+		t.Fatal("No subscription for zigbee2mqtt/+")
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	cases := []struct {
+		payload string
+		ok      bool
+	}{
+		{`{"temperature": 21.5, "battery": 90}`, false},
+		{`{"update": {"state": "available"}}`, true},
+		{`{"temperature": 21.7, "battery": 90}`, false},
+		{`{"update": {"state": "available"}, "battery": 89}`, false},
+	}
+	for i, c := range cases {
+		_, ok := sub.accept("zigbee2mqtt/lamp", c.payload)
+		if ok != c.ok {
+			t.Errorf("%d: %v: expected %v, got %v", i, c.payload, c.ok, ok)
+		}
+	}
+	if logged.Len() > 0 {
+		t.Errorf("Expected no log output, got %q", logged.String())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Probe Command --------------------------------------------------------------
+
+// A capability of the notifications server
+// and the options which depend on it.
+type probeFeature struct {
+	capability string
+	options    string
+}
+
+var probeFeatures = []probeFeature{
+	{"actions", "copy, open, link, attach_full, feedback_topic"},
+	{"body", "body"},
+	{"body-markup", "markup in body templates"},
+	{"body-hyperlinks", "links in body templates"},
+	{"body-images", "images in body templates"},
+	{"icon-static", "icon, image, image payloads"},
+	{"sound", "sound, sound_every"},
+	{"persistence", "notifications stay until dismissed"},
+}
+
+// Check which features the notifications server supports
+// and print which options will have an effect.
+func probeServer() error {
+	conn, err := openBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	server := conn.Object(DESTINATION, OBJ_PATH)
+
+	var name, vendor, version, spec string
+	err = server.Call(DESTINATION+".GetServerInformation", 0).Store(&name, &vendor, &version, &spec)
+	if err != nil {
+		return fmt.Errorf("No notifications server? %v", err)
+	}
+	fmt.Printf("Server: %v %v (%v), specification %v\n\n", name, version, vendor, spec)
+
+	var capabilities []string
+	err = server.Call(DESTINATION+".GetCapabilities", 0).Store(&capabilities)
+	if err != nil {
+		return err
+	}
+	supported := make(map[string]bool)
+	for _, c := range capabilities {
+		supported[c] = true
+	}
+
+	fmt.Printf("%-16s %-9s  %s\n", "FEATURE", "SUPPORTED", "OPTIONS")
+	for _, f := range probeFeatures {
+		fmt.Printf("%-16s %-9s  %s\n", f.capability, yesNo(supported[f.capability]), f.options)
+	}
+
+	replaced, err := probeReplace(server)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-16s %-9s  %s\n", "replacement", yesNo(replaced),
+		"replace, replace_group, show_unread, progress")
+
+	others := make([]string, 0)
+	for _, c := range capabilities {
+		if strings.HasPrefix(c, "x-") {
+			others = append(others, c)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Printf("\nOther capabilities: %v\n", strings.Join(others, ", "))
+	}
+	return nil
+}
+
+// Send a notification, replace it and close it again.
+// Returns true if the server kept the ID, i.e. replaced the notification.
+func probeReplace(server dbus.BusObject) (bool, error) {
+	n := &Notification{
+		Title:   APPNAME,
+		Body:    "Checking notification features...",
+		Icon:    "dialog-information",
+		Urgency: "low",
+	}
+
+	var id uint32
+	err := server.Call(NOTIFY_METHOD, 0, notifyArgs(n)...).Store(&id)
+	if err != nil {
+		return false, err
+	}
+
+	n.Replaces = id
+	n.Body = "Done."
+	var replacedID uint32
+	err = server.Call(NOTIFY_METHOD, 0, notifyArgs(n)...).Store(&replacedID)
+	if err != nil {
+		return false, err
+	}
+
+	server.Call(DESTINATION+".CloseNotification", 0, replacedID)
+	return replacedID == id, nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
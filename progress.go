@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Progress -------------------------------------------------------------------
+//
+// A subscription with `progress` shows a numeric value (e.g. percent done)
+// as a progress bar, if the notifications server supports it,
+// and closes the notification once the value reaches `complete`.
+
+// Default delay before a completed notification is closed.
+const defaultProgressCloseAfter = 5
+
+// Configuration for progress notifications.
+type ProgressConfig struct {
+	Complete   *float64 `json:"complete"`    // value when done, default 100
+	CloseAfter int      `json:"close_after"` // seconds to wait before closing
+	Done       string   `json:"done"`        // title for a final notification
+}
+
+func (p *ProgressConfig) complete() float64 {
+	if p.Complete != nil {
+		return *p.Complete
+	}
+	return 100
+}
+
+func (p *ProgressConfig) closeAfter() time.Duration {
+	if p.CloseAfter > 0 {
+		return time.Duration(p.CloseAfter) * time.Second
+	}
+	return defaultProgressCloseAfter * time.Second
+}
+
+// Set the progress value hint (0 to 100) for a notification.
+func (s *Subscription) applyProgress(n *Notification, ctx *TemplateContext) {
+	value, err := s.value(ctx)
+	complete := s.Progress.complete()
+	if err != nil || complete == 0 {
+		return
+	}
+
+	percent := int32(value / complete * 100)
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	n.Progress = &percent
+}
+
+// Close the notification for the topic after a delay
+// if the value reached `complete`.
+// A message with a lower value cancels this, e.g. if a new job started.
+func (s *Subscription) checkCompletion(ctx *TemplateContext) {
+	value, err := s.value(ctx)
+	if err != nil {
+		log.Printf("WARNING: Ignoring non-numeric progress on %v: %v", ctx.topic, err)
+		return
+	}
+	done := value >= s.Progress.complete()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.progressTimers == nil {
+		s.progressTimers = make(map[string]*time.Timer)
+	}
+	if timer, ok := s.progressTimers[ctx.topic]; ok {
+		timer.Stop()
+		delete(s.progressTimers, ctx.topic)
+	}
+	if !done {
+		return
+	}
+
+	s.progressTimers[ctx.topic] = time.AfterFunc(s.Progress.closeAfter(), func() {
+		s.mutex.Lock()
+		delete(s.progressTimers, ctx.topic)
+		s.mutex.Unlock()
+
+		s.clear(ctx.topic)
+		if s.Progress.Done != "" {
+			s.showDone(ctx)
+		}
+	})
+}
+
+// Show the final notification for a completed progress.
+func (s *Subscription) showDone(ctx *TemplateContext) {
+	config := s.config
+	title, err := s.templates.render("done", s.Progress.Done, ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to render done template: %v", err)
+		return
+	}
+
+	icon := s.Icon
+	if icon == "" {
+		icon = config.Icon
+	}
+	_, err = notifyWithRetry(&Notification{
+		Title:   title,
+		Icon:    icon,
+		Urgency: s.Urgency,
+	})
+	if err != nil {
+		log.Printf("ERROR: %v: Failed to send notification: %v", s.label(), err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// Prune Command --------------------------------------------------------------
+//
+// `mqtt-dbus-notify prune` reads the configuration file, removes options
+// which are not known and prints the result.
+// Unknown options are ignored with a warning, this cleans them up.
+// The result is JSON like the input; comments and the order of the keys
+// are not kept, and there is no conversion to other formats.
+// Keys are matched case-insensitively, like the JSON decoder does.
+// Files encrypted with sops are not pruned, the result could not be
+// decrypted without the sops metadata and the MAC over all values.
+
+// Print the pruned configuration to stdout and the removed keys to stderr.
+func pruneConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw interface{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return configError(path, data, err)
+	}
+	if top, ok := raw.(map[string]interface{}); ok {
+		if _, encrypted := top["sops"]; encrypted {
+			return fmt.Errorf("%v is encrypted with sops, edit it with sops instead", path)
+		}
+	}
+
+	removed := prune(raw, jsonSchema(reflect.TypeOf(Config{})), "")
+	for _, key := range removed {
+		fmt.Fprintf(os.Stderr, "Removed unknown option %v\n", key)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(raw)
+}
+
+// Remove all keys from a decoded JSON value which are not in the schema.
+// Returns the paths of the removed keys.
+func prune(value interface{}, schema map[string]interface{}, path string) []string {
+	removed := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, isStruct := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			var childSchema map[string]interface{}
+			if isStruct {
+				s := lookupProperty(properties, key)
+				if s == nil {
+					delete(v, key)
+					removed = append(removed, childPath)
+					continue
+				}
+				childSchema = s
+			} else {
+				// a map, all values have the same schema
+				childSchema, _ = schema["additionalProperties"].(map[string]interface{})
+			}
+			removed = append(removed, prune(v[key], childSchema, childPath)...)
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range v {
+			removed = append(removed, prune(item, items, fmt.Sprintf("%v[%d]", path, i))...)
+		}
+	}
+	return removed
+}
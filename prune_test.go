@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	cases := []struct {
+		config  string
+		removed []string
+	}{
+		{`{"host": "broker", "colour": "red"}`, []string{"colour"}},
+		{`{"Host": "broker", "subscriptions": [{"Topic": "a/b"}]}`, []string{}},
+		{`{"subscriptions": [{"topic": "a/b", "sticky": true}]}`, []string{"subscriptions[0].sticky"}},
+	}
+
+	schema := jsonSchema(reflect.TypeOf(Config{}))
+	for _, c := range cases {
+		var raw interface{}
+		err := json.Unmarshal([]byte(c.config), &raw)
+		if err != nil {
+			t.Fatalf("%v: %v", c.config, err)
+		}
+		removed := prune(raw, schema, "")
+		if !reflect.DeepEqual(removed, c.removed) {
+			t.Errorf("%v: expected %v removed, got %v", c.config, c.removed, removed)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Retained Values ------------------------------------------------------------
+
+// Latest payload for each topic which matches one of the `retained_topics`,
+// which may contain wildcards.
+var retainedValues = make(map[string]string)
+var retainedMutex sync.Mutex
+
+// Store the latest value for a topic.
+func setRetained(topic, payload string) {
+	retainedMutex.Lock()
+	defer retainedMutex.Unlock()
+	retainedValues[topic] = payload
+}
+
+// Template function to get the latest value for one of the retained topics.
+// Returns an empty string if no message was received for the topic yet.
+func retained(topic string) (string, error) {
+	config := currentConfig()
+	if strings.ContainsAny(topic, "+#") {
+		return "", fmt.Errorf("Topic %q must not contain wildcards", topic)
+	}
+	known := false
+	for _, filter := range config.RetainedTopics {
+		if topicMatches(filter, topic) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return "", fmt.Errorf("Topic %q is not in retained_topics", topic)
+	}
+
+	retainedMutex.Lock()
+	defer retainedMutex.Unlock()
+	return retainedValues[topic], nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRetained(t *testing.T) {
+	previous := currentConfig()
+	setConfig(&Config{RetainedTopics: []string{"home/mode", "home/+/window", "alarm/#"}})
+	defer setConfig(previous)
+
+	setRetained("home/mode", "away")
+	setRetained("home/kitchen/window", "open")
+	setRetained("alarm/zone/1", "armed")
+
+	cases := []struct {
+		topic    string
+		expected string
+		ok       bool
+	}{
+		{"home/mode", "away", true},
+		{"home/kitchen/window", "open", true},
+		{"home/bedroom/window", "", true}, // nothing received yet
+		{"alarm/zone/1", "armed", true},
+		{"home/kitchen/door", "", false},
+		{"home/+/window", "", false},
+	}
+	for _, c := range cases {
+		actual, err := retained(c.topic)
+		if (err == nil) != c.ok || actual != c.expected {
+			t.Errorf("%v: expected %q (ok: %v), got %q (%v)", c.topic, c.expected, c.ok, actual, err)
+		}
+	}
+}
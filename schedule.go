@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Schedules ------------------------------------------------------------------
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// A time range on selected weekdays, like "Mon-Fri 08:00-18:00".
+type timeRange struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int
+}
+
+// Parse a time range specification.
+// The days are optional and can be a list ("Mon,Wed") or a range ("Mon-Fri").
+// Time ranges which end before they start span midnight.
+func parseTimeRange(spec string) (*timeRange, error) {
+	r := &timeRange{}
+	fields := strings.Fields(spec)
+
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		days, hours = "sun-sat", fields[0]
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return nil, fmt.Errorf("Invalid schedule %q", spec)
+	}
+
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdayNames[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("Invalid weekday %q in schedule %q", bounds[0], spec)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, ok = weekdayNames[bounds[1]]
+			if !ok {
+				return nil, fmt.Errorf("Invalid weekday %q in schedule %q", bounds[1], spec)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			r.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	bounds := strings.SplitN(hours, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Invalid time range %q in schedule %q", hours, spec)
+	}
+	var err error
+	r.start, err = parseClock(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	r.end, err = parseClock(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Parse "HH:MM" into minutes since midnight. "24:00" is allowed as end of day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	_, err := fmt.Sscanf(s, "%d:%d", &h, &m)
+	if err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("Invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Whether the given time is within the range.
+func (r *timeRange) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if r.start <= r.end {
+		return r.days[t.Weekday()] && minutes >= r.start && minutes < r.end
+	}
+
+	// spans midnight, the range belongs to the day it starts on
+	if minutes >= r.start {
+		return r.days[t.Weekday()]
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return minutes < r.end && r.days[yesterday]
+}
+
+// Whether the subscription is active at the given time.
+// Subscriptions without a schedule are always active.
+func (s *Subscription) active(t time.Time) bool {
+	if len(s.Schedule) == 0 {
+		return true
+	}
+	return inSchedule(s.Schedule, t)
+}
+
+// Whether the given time is within any of the time ranges.
+func inSchedule(specs []string, t time.Time) bool {
+	for _, spec := range specs {
+		r, err := parseTimeRange(spec)
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+			return true
+		}
+		if r.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Quiet Hours ----------------------------------------------------------------
+
+// Changes to notifications during certain hours, e.g. at night.
+type QuietHours struct {
+	Schedule []string `json:"schedule"`
+	Urgency  string   `json:"urgency"` // the highest urgency
+	Timeout  *int     `json:"timeout"` // seconds, 0 to never expire
+}
+
+// Lower the urgency and change the timeout if the time is within
+// the quiet hours of the subscription.
+func (s *Subscription) applyQuietHours(n *Notification, t time.Time) {
+	q := s.QuietHours
+	if q == nil || !inSchedule(q.Schedule, t) {
+		return
+	}
+
+	current, ok := urgencyLevels[n.Urgency]
+	if !ok {
+		current = urgencyLevels["normal"]
+	}
+	if limit, ok := urgencyLevels[q.Urgency]; ok && limit < current {
+		n.Urgency = q.Urgency
+	}
+	if q.Timeout != nil {
+		timeout := int32(*q.Timeout * 1000)
+		n.Timeout = &timeout
+	}
+}
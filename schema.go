@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Config Schema --------------------------------------------------------------
+
+// Allowed values for config options, by JSON key.
+var schemaEnums = map[string][]string{
+	"urgency":           {"low", "normal", "critical"},
+	"compression":       {"gzip", "zlib", "deflate"},
+	"pause_policy":      {policyDrop, policyQueue},
+	"stale_policy":      {stalePolicyMark, stalePolicyDrop, stalePolicySummary},
+	"on_template_error": {templateErrorDrop, templateErrorRaw, templateErrorNotify},
+	"on_invalid":        {invalidDrop, invalidRaw, invalidNotify, invalidFallback},
+	"on_expired":        {stalePolicyDrop, stalePolicyMark},
+	"transport":         {transportTCP, transportWebsocket},
+	"engine":            {engineTemplate, engineJQ},
+	"topic_decoding":    {topicDecodingURL, topicDecodingUTF8},
+	"hooks":             hookEvents,
+	"type":              {transformTrim, transformJSON, transformReplace, transformConvert, transformMap},
+}
+
+// Print the JSON Schema for the configuration file.
+func printSchema() error {
+	schema := jsonSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = APPNAME + " configuration"
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(schema)
+}
+
+// Create the JSON Schema for a Go type, based on its JSON encoding.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.PkgPath != "" || key == "" || key == "-" {
+				continue
+			}
+			property := jsonSchema(field.Type)
+			if values, ok := schemaEnums[key]; ok {
+				if field.Type.Kind() == reflect.Map {
+					// restricts the keys, e.g. hook events
+					property["propertyNames"] = map[string]interface{}{"enum": values}
+				} else {
+					property["enum"] = values
+				}
+			}
+			properties[key] = property
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// Config Validation ----------------------------------------------------------
+
+// Describe an error from decoding the config file with its line and column.
+func configError(path string, data []byte, err error) error {
+	offset := int64(-1)
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxError) {
+		offset = syntaxError.Offset
+	} else if errors.As(err, &typeError) {
+		offset = typeError.Offset
+		err = fmt.Errorf("%v: expected %v, got %v",
+			typeError.Field, typeError.Type, typeError.Value)
+	}
+
+	if offset < 0 {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	line, column := position(data, offset)
+	return fmt.Errorf("%v:%d:%d: %v", path, line, column, err)
+}
+
+// An option in the config file which is not in the schema.
+type unknownOption struct {
+	Path   string // e.g. "subscriptions[2].titel"
+	Offset int64  // of the key in the file
+}
+
+// Find the options in the config file which are not known,
+// with their position. They are ignored when the config is decoded.
+func findUnknownOptions(data []byte) []unknownOption {
+	found := make([]unknownOption, 0)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	schema := jsonSchema(reflect.TypeOf(Config{}))
+	for {
+		// syntax errors are reported when decoding the config
+		if walkUnknownOptions(decoder, data, schema, "", &found) != nil {
+			return found
+		}
+	}
+}
+
+// Read one JSON value from the decoder and add its unknown options to found.
+func walkUnknownOptions(decoder *json.Decoder, data []byte, schema map[string]interface{}, path string, found *[]unknownOption) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		properties, isStruct := schema["properties"].(map[string]interface{})
+		for decoder.More() {
+			token, err = decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			var child map[string]interface{}
+			if isStruct {
+				child = lookupProperty(properties, key)
+				if child == nil {
+					// the decoder is just behind the closing quote of the key
+					end := decoder.InputOffset()
+					start := int64(bytes.LastIndexByte(data[:end-1], '"'))
+					*found = append(*found, unknownOption{Path: childPath, Offset: start})
+				}
+			} else {
+				// a map, all values have the same schema
+				child, _ = schema["additionalProperties"].(map[string]interface{})
+			}
+			err = walkUnknownOptions(decoder, data, child, childPath, found)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+	case json.Delim('['):
+		items, _ := schema["items"].(map[string]interface{})
+		for i := 0; decoder.More(); i++ {
+			err = walkUnknownOptions(decoder, data, items, fmt.Sprintf("%v[%d]", path, i), found)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+	}
+	return err
+}
+
+// The schema of a property. Like the JSON decoder,
+// falls back to a case-insensitive match of the key.
+func lookupProperty(properties map[string]interface{}, key string) map[string]interface{} {
+	if property, ok := properties[key].(map[string]interface{}); ok {
+		return property
+	}
+	for name, property := range properties {
+		if strings.EqualFold(name, key) {
+			p, _ := property.(map[string]interface{})
+			return p
+		}
+	}
+	return nil
+}
+
+// Line and column (starting at 1) for an offset in data.
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndex(before, []byte("\n"))
+	return line, column
+}
+
+// Check the config for invalid values.
+func (c *Config) validate() error {
+	err := checkEnum("pause_policy", c.PausePolicy)
+	if err != nil {
+		return err
+	}
+	err = checkEnum("stale_policy", c.StalePolicy)
+	if err != nil {
+		return err
+	}
+	err = checkEnum("on_template_error", c.OnTemplateError)
+	if err != nil {
+		return err
+	}
+	err = checkEnum("transport", c.Transport)
+	if err != nil {
+		return err
+	}
+	if c.Path != "" && c.Transport != transportWebsocket {
+		return fmt.Errorf("path: requires \"transport\": \"websocket\"")
+	}
+	err = c.Connection.validate()
+	if err != nil {
+		return err
+	}
+	if c.Availability != nil {
+		err = c.Availability.validate()
+		if err != nil {
+			return err
+		}
+	}
+	if c.QoS < 0 || c.QoS > 2 {
+		return fmt.Errorf("qos: must be 0, 1 or 2")
+	}
+	if c.CAFile != "" && !c.Secure {
+		return fmt.Errorf("ca_file: requires \"secure\": true")
+	}
+	if (c.CertFile != "" || c.KeyFile != "") && !c.Secure {
+		return fmt.Errorf("cert_file, key_file: require \"secure\": true")
+	}
+
+	for event := range c.Hooks {
+		err = checkEnum("hooks", event)
+		if err != nil {
+			return err
+		}
+	}
+
+	// an invalid subscription is disabled, the others still work
+	names := make(map[string]bool)
+	valid := make([]*Subscription, 0, len(c.Subscriptions))
+	for i, sub := range c.Subscriptions {
+		prefix := fmt.Sprintf("subscriptions[%d]", i)
+		if sub.Name != "" && names[sub.Name] {
+			err = fmt.Errorf("%v.name: %q is used more than once", prefix, sub.Name)
+		} else {
+			err = sub.validate(prefix)
+		}
+		if err != nil {
+			warnStartup("%v, subscription is disabled", err)
+			continue
+		}
+		if sub.Name != "" {
+			names[sub.Name] = true
+		}
+		valid = append(valid, sub)
+	}
+	c.Subscriptions = valid
+	return nil
+}
+
+// Check a subscription for invalid values.
+// Errors start with the prefix, which locates the subscription in the config.
+func (s *Subscription) validate(prefix string) error {
+	var err error
+	if s.QoS != nil && (*s.QoS < 0 || *s.QoS > 2) {
+		return fmt.Errorf("%v.qos: must be 0, 1 or 2", prefix)
+	}
+	if s.Topic != "" && len(s.Topics) > 0 {
+		return fmt.Errorf("%v: use either topic or topics", prefix)
+	}
+	for _, check := range []struct{ key, value string }{
+		{"urgency", s.Urgency},
+		{"compression", s.Compression},
+		{"topic_decoding", s.TopicDecoding},
+		{"on_invalid", s.OnInvalid},
+		{"on_expired", s.OnExpired},
+		{"engine", s.Engine},
+	} {
+		err = checkEnum(check.key, check.value)
+		if err != nil {
+			return fmt.Errorf("%v.%v", prefix, err)
+		}
+	}
+	for _, spec := range s.Schedule {
+		_, err = parseTimeRange(spec)
+		if err != nil {
+			return fmt.Errorf("%v.schedule: %v", prefix, err)
+		}
+	}
+	if s.OnInvalid == invalidFallback && s.InvalidTitle == "" {
+		return fmt.Errorf("%v.invalid_title: required for \"on_invalid\": \"fallback\"", prefix)
+	}
+	if baseContentType(s.ContentType) == contentTypeCBOR {
+		return fmt.Errorf("%v.content_type: CBOR payloads are not supported", prefix)
+	}
+	if s.MaxAge < 0 {
+		return fmt.Errorf("%v.max_age: must not be negative", prefix)
+	}
+	if s.SoundEvery != nil && *s.SoundEvery < 0 {
+		return fmt.Errorf("%v.sound_every: must not be negative", prefix)
+	}
+	if s.Engine == engineJQ {
+		if s.JQ == "" {
+			return fmt.Errorf("%v.jq: required for \"engine\": \"jq\"", prefix)
+		}
+		s.jqCode, err = compileJQ(s.JQ)
+		if err != nil {
+			return fmt.Errorf("%v.jq: %v", prefix, err)
+		}
+	}
+	if s.Pair != nil {
+		err = s.Pair.prepare()
+		if err != nil {
+			return fmt.Errorf("%v.pair.%v", prefix, err)
+		}
+	}
+	if s.ClearWhen != "" {
+		s.clearPattern, err = regexp.Compile(s.ClearWhen)
+		if err != nil {
+			return fmt.Errorf("%v.clear_when: %v", prefix, err)
+		}
+	}
+	if q := s.QuietHours; q != nil {
+		err = checkEnum("urgency", q.Urgency)
+		if err != nil {
+			return fmt.Errorf("%v.quiet_hours.%v", prefix, err)
+		}
+		for _, spec := range q.Schedule {
+			_, err = parseTimeRange(spec)
+			if err != nil {
+				return fmt.Errorf("%v.quiet_hours.schedule: %v", prefix, err)
+			}
+		}
+	}
+	for j, step := range s.Transform {
+		err = step.prepare()
+		if err != nil {
+			return fmt.Errorf("%v.transform[%d]: %v", prefix, j, err)
+		}
+	}
+	if s.Aggregate != nil && s.Aggregate.Window <= 0 {
+		return fmt.Errorf("%v.aggregate.window: must be greater than 0", prefix)
+	}
+	return nil
+}
+
+// Check that an option has one of the allowed values. Empty is allowed.
+func checkEnum(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	for _, allowed := range schemaEnums[key] {
+		if value == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: invalid value %q, expected one of %v",
+		key, value, strings.Join(schemaEnums[key], ", "))
+}
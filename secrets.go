@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Secrets --------------------------------------------------------------------
+//
+// Passwords and other secrets in the config file can be encrypted, so that
+// the file can be shared publicly:
+//
+// - the whole file is encrypted with sops (https://github.com/mozilla/sops),
+//   detected by its "sops" metadata key
+// - single values are encrypted with age (https://age-encryption.org/),
+//   written as "age:" followed by the base64 encoded ciphertext
+//
+// Decryption uses the `sops` and `age` commands. The age identity is read
+// from the same file as sops uses or, if there is none, from the keyring
+// with `secret-tool`. An identity from the keyring is passed to the
+// commands through a pipe or the environment, it is not written to disk.
+
+const agePrefix = "age:"
+
+// Attributes of the age identity in the keyring.
+var keyringAttributes = []string{"application", APPNAME, "type", "age-identity"}
+
+// Decrypt a sops encrypted config file.
+// Returns the data unchanged if the file is not encrypted.
+func decryptConfigFile(path string, data []byte) ([]byte, error) {
+	var probe map[string]json.RawMessage
+	if json.Unmarshal(data, &probe) != nil {
+		return data, nil // reported by the regular decoder
+	}
+	if _, encrypted := probe["sops"]; !encrypted {
+		return data, nil
+	}
+
+	cmd := exec.Command("sops", "--decrypt", "--input-type", "json",
+		"--output-type", "json", path)
+	cmd.Stderr = os.Stderr
+	// sops may also use other keys, so a missing identity is not an error
+	identity, err := ageIdentity()
+	if err == nil && identity.key != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY="+identity.key)
+	}
+	plain, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt %v with sops: %v", path, err)
+	}
+	return plain, nil
+}
+
+// Decrypt a single age encrypted value.
+// Values without the "age:" prefix are returned unchanged.
+func decryptValue(value string) (string, error) {
+	if !strings.HasPrefix(value, agePrefix) {
+		return value, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, agePrefix))
+	if err != nil {
+		return "", err
+	}
+	identity, err := ageIdentity()
+	if err != nil {
+		return "", err
+	}
+
+	var pipe *os.File
+	file := identity.file
+	if identity.key != "" {
+		// age reads the identity from its fd 3
+		r, w, err := os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		go func() {
+			w.WriteString(identity.key + "\n")
+			w.Close()
+		}()
+		pipe = r
+		file = "/dev/fd/3"
+	}
+
+	cmd := exec.Command("age", "--decrypt", "--identity", file)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	if pipe != nil {
+		cmd.ExtraFiles = []*os.File{pipe}
+	}
+	plain, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt value with age: %v", err)
+	}
+	return string(plain), nil
+}
+
+// An age identity, either the path to a file or the key itself.
+type identity struct {
+	file string
+	key  string
+}
+
+// The age identity from the file used by sops, or from the keyring.
+func ageIdentity() (identity, error) {
+	path := os.Getenv("SOPS_AGE_KEY_FILE")
+	if path == "" {
+		base := os.Getenv("XDG_CONFIG_HOME")
+		if base == "" {
+			currentUser, err := user.Current()
+			if err != nil {
+				return identity{}, err
+			}
+			base = filepath.Join(currentUser.HomeDir, ".config")
+		}
+		path = filepath.Join(base, "sops", "age", "keys.txt")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return identity{file: path}, nil
+	}
+
+	out, err := exec.Command("secret-tool", append([]string{"lookup"}, keyringAttributes...)...).Output()
+	key := strings.TrimSpace(string(out))
+	if err != nil || key == "" {
+		return identity{}, fmt.Errorf("No age identity in %v or in the keyring (%v)",
+			path, strings.Join(keyringAttributes, " "))
+	}
+	return identity{key: key}, nil
+}
+
+// Decrypt all secret values in the config.
+func (c *Config) decryptSecrets() error {
+	secrets := []*string{&c.Password, &c.KeyPassword}
+	for _, source := range c.Ntfy {
+		secrets = append(secrets, &source.Password, &source.Token)
+	}
+
+	for _, secret := range secrets {
+		plain, err := decryptValue(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = plain
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Status Service -------------------------------------------------------------
+
+const SERVICE_NAME = "net.akeil.MQTTDBusNotify"
+const SERVICE_PATH = dbus.ObjectPath("/net/akeil/MQTTDBusNotify")
+
+// D-Bus interface which exposes the status of the running daemon.
+type StatusService struct{}
+
+// Number of unread notifications per topic.
+func (s StatusService) Unread() (map[string]uint32, *dbus.Error) {
+	return unreadCounts(), nil
+}
+
+// Whether notifications are paused.
+func (s StatusService) Paused() (bool, *dbus.Error) {
+	return isPaused(), nil
+}
+
+// Counters per subscription.
+func (s StatusService) Stats() (map[string]map[string]int64, *dbus.Error) {
+	return allStatistics(), nil
+}
+
+// Whether the notifications server responded to the last liveness check,
+// the name and version of the server or the error,
+// and the time of the check (Unix timestamp).
+func (s StatusService) Health() (bool, string, int64, *dbus.Error) {
+	h := serverStatus()
+	detail := h.server
+	if !h.ok {
+		detail = h.err
+	}
+	var checked int64
+	if !h.checked.IsZero() {
+		checked = h.checked.Unix()
+	}
+	return h.ok, detail, checked, nil
+}
+
+// The address of the MQTT broker, whether it is connected,
+// the number of connects and lost connections, the last error
+// and the time of the last connect or disconnect (Unix timestamp).
+func (s StatusService) Broker() (string, bool, int64, int64, string, int64, *dbus.Error) {
+	b := brokerInfo()
+	var since int64
+	if !b.since.IsZero() {
+		since = b.since.Unix()
+	}
+	return b.url, b.connected, b.connects, b.disconnects, b.lastError, since, nil
+}
+
+// Export the status service on the session bus.
+func exportService() error {
+	err := dbusConn.Export(StatusService{}, SERVICE_PATH, SERVICE_NAME)
+	if err != nil {
+		return err
+	}
+
+	reply, err := dbusConn.RequestName(SERVICE_NAME, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		log.Printf("WARNING: D-Bus name %v is already taken", SERVICE_NAME)
+	}
+	return nil
+}
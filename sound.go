@@ -0,0 +1,22 @@
+package main
+
+// Sounds ---------------------------------------------------------------------
+//
+// A replaced notification plays its sound again on every update.
+// For topics which update frequently, `sound_every` limits the sound
+// to the first notification and every Nth update after it.
+
+// Set the sound hints for a notification.
+// Updates is the number of times the displayed notification
+// has been replaced since the user last interacted with it.
+func (s *Subscription) applySound(n *Notification, updates uint32) {
+	n.Sound = s.Sound
+	if s.SoundEvery == nil || updates == 0 {
+		return
+	}
+
+	every := uint32(*s.SoundEvery)
+	if every == 0 || updates%every != 0 {
+		n.Silent = true
+	}
+}
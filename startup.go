@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Startup Problems -----------------------------------------------------------
+//
+// Problems with a single part of the program do not stop it from starting.
+// An invalid subscription is disabled, a missing session bus is retried,
+// and the MQTT client keeps trying to connect in the background.
+// The problems are logged as warnings and, once notifications can be shown,
+// listed in a single notification.
+
+// Longest wait between attempts to reach a subsystem at startup.
+const maxStartupBackoff = time.Minute
+
+var startupWarnings = make([]string, 0)
+var startupDone = false
+var startupMutex sync.Mutex
+
+// Log a problem during startup and keep it for the startup notification.
+func warnStartup(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("WARNING: %v", msg)
+
+	startupMutex.Lock()
+	defer startupMutex.Unlock()
+	if !startupDone {
+		startupWarnings = append(startupWarnings, msg)
+	}
+}
+
+// Show a notification with the problems during startup, if there were any.
+// Later problems (e.g. on reload) are only logged.
+func showStartupWarnings() {
+	startupMutex.Lock()
+	startupDone = true
+	warnings := startupWarnings
+	startupMutex.Unlock()
+
+	if len(warnings) == 0 {
+		return
+	}
+	_, err := notify(&Notification{
+		Title: fmt.Sprintf("%v started with %d problems", APPNAME, len(warnings)),
+		Body:  strings.Join(warnings, "\n"),
+		Icon:  "dialog-warning",
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to send notification: %v", err)
+	}
+}
+
+// Call fn until it succeeds, waiting longer after each failure.
+// Gives up if the program is interrupted in the meantime.
+// Other signals (pause, resume, reload) which arrive while waiting
+// are returned, to be handled once the program has started.
+func retry(name string, fn func() error, signals chan os.Signal) ([]os.Signal, error) {
+	deferred := make([]os.Signal, 0)
+	backoff := time.Second
+	for {
+		err := fn()
+		if err == nil {
+			return deferred, nil
+		}
+		log.Printf("WARNING: %v failed, retrying in %v: %v", name, backoff, err)
+
+		wait := time.After(backoff)
+		for waiting := true; waiting; {
+			select {
+			case sig := <-signals:
+				if sig == os.Interrupt {
+					return deferred, errors.New(name + ": interrupted")
+				}
+				deferred = append(deferred, sig)
+			case <-wait:
+				waiting = false
+			}
+		}
+		backoff *= 2
+		if backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
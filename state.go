@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State ----------------------------------------------------------------------
+
+// Runtime state which is kept across restarts.
+type State struct {
+	ReplaceIDs map[string]uint32    `json:"replace_ids"`
+	PairStarts map[string]time.Time `json:"pair_starts"`
+}
+
+var state = &State{
+	ReplaceIDs: make(map[string]uint32),
+	PairStarts: make(map[string]time.Time),
+}
+var stateMutex sync.Mutex
+
+// Get the notification ID to replace for the given key.
+// Returns 0 if there is no previous notification.
+func getReplaceID(key string) uint32 {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return state.ReplaceIDs[key]
+}
+
+// Remember the notification ID for the given key and persist the state.
+func setReplaceID(key string, id uint32) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if state.ReplaceIDs[key] == id {
+		return
+	}
+	state.ReplaceIDs[key] = id
+	err := saveState()
+	if err != nil {
+		log.Printf("WARNING: Failed to save state: %v", err)
+	}
+}
+
+// Path to the state file below XDG_STATE_HOME.
+func statePath() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(currentUser.HomeDir, ".local", "state")
+	}
+	return filepath.Join(base, APPNAME, "state.json"), nil
+}
+
+// Read the state file and set the global `state` variable.
+// A missing state file is not an error.
+func loadState() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := &State{}
+	err = json.NewDecoder(f).Decode(s)
+	if err != nil {
+		return err
+	}
+	if s.ReplaceIDs == nil {
+		s.ReplaceIDs = make(map[string]uint32)
+	}
+	if s.PairStarts == nil {
+		s.PairStarts = make(map[string]time.Time)
+	}
+
+	stateMutex.Lock()
+	state = s // global
+	stateMutex.Unlock()
+	return nil
+}
+
+// Write the global `state` to the state file.
+// Expects the caller to hold `stateMutex`.
+func saveState() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so we never leave a truncated state file
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(state)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statistics -----------------------------------------------------------------
+
+const statReceived = "received"
+const statNotified = "notified"
+const statFiltered = "filtered"
+const statLimited = "limited" // held back by `max_visible`
+const statErrors = "errors"
+const statPanics = "panics"
+const statLastMessage = "last_message" // Unix timestamp
+const statRendered = "rendered"
+const statRenderTime = "render_time_us"    // total, in microseconds
+const statRenderMax = "render_time_max_us" // slowest, in microseconds
+
+// Increase one of the counters for this subscription.
+func (s *Subscription) count(stat string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]int64)
+	}
+	s.stats[stat]++
+	switch stat {
+	case statReceived:
+		s.stats[statLastMessage] = time.Now().Unix()
+	case statErrors, statPanics:
+		recordError()
+	}
+}
+
+// Record how long it took to render a notification.
+func (s *Subscription) observeRender(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]int64)
+	}
+	us := d.Nanoseconds() / 1000
+	s.stats[statRendered]++
+	s.stats[statRenderTime] += us
+	if us > s.stats[statRenderMax] {
+		s.stats[statRenderMax] = us
+	}
+}
+
+// Count received messages and shown notifications.
+func statsSink(e Event) {
+	switch e.Type {
+	case eventReceived:
+		e.Subscription.count(statReceived)
+	case eventShown:
+		e.Subscription.count(statNotified)
+	}
+}
+
+// Get a copy of the counters for this subscription.
+func (s *Subscription) statistics() map[string]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := map[string]int64{
+		statReceived:    0,
+		statNotified:    0,
+		statFiltered:    0,
+		statLimited:     0,
+		statErrors:      0,
+		statPanics:      0,
+		statLastMessage: 0,
+		statRendered:    0,
+		statRenderTime:  0,
+		statRenderMax:   0,
+	}
+	for stat, value := range s.stats {
+		result[stat] = value
+	}
+	return result
+}
+
+// Counters for all subscriptions, by topic.
+func allStatistics() map[string]map[string]int64 {
+	config := currentConfig()
+	result := make(map[string]map[string]int64)
+	for i, sub := range config.Subscriptions {
+		key := sub.label()
+		if _, exists := result[key]; exists {
+			key = fmt.Sprintf("%v (%d)", key, i+1)
+		}
+		result[key] = sub.statistics()
+	}
+	return result
+}
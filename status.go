@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	dbus "github.com/godbus/dbus"
+)
+
+// Status Command -------------------------------------------------------------
+
+// Print the status of the running daemon, retrieved over D-Bus.
+func printStatus() error {
+	conn, err := openBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	service := conn.Object(SERVICE_NAME, SERVICE_PATH)
+
+	var paused bool
+	err = service.Call(SERVICE_NAME+".Paused", 0).Store(&paused)
+	if err != nil {
+		return fmt.Errorf("Daemon not running? %v", err)
+	}
+
+	var stats map[string]map[string]int64
+	err = service.Call(SERVICE_NAME+".Stats", 0).Store(&stats)
+	if err != nil {
+		return err
+	}
+
+	var unread map[string]uint32
+	err = service.Call(SERVICE_NAME+".Unread", 0).Store(&unread)
+	if err != nil {
+		return err
+	}
+
+	var healthy bool
+	var server string
+	var checked int64
+	err = service.Call(SERVICE_NAME+".Health", 0).Store(&healthy, &server, &checked)
+	if err != nil {
+		return err
+	}
+
+	// older daemons do not report the broker
+	var url, lastError string
+	var connected bool
+	var connects, disconnects, since int64
+	err = service.Call(SERVICE_NAME+".Broker", 0).Store(&url, &connected,
+		&connects, &disconnects, &lastError, &since)
+	brokerKnown := !isUnknownMethod(err)
+	if err != nil && brokerKnown {
+		return err
+	}
+
+	fmt.Printf("Paused: %v\n", paused)
+	if !brokerKnown {
+		fmt.Printf("Broker: unknown\n")
+	} else if url == "" {
+		fmt.Printf("Broker: none\n")
+	} else {
+		state := "NOT CONNECTED"
+		if connected {
+			state = "connected"
+		}
+		if since > 0 {
+			state += " since " + time.Unix(since, 0).Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("Broker: %v, %v (%d connects, %d lost)\n",
+			url, state, connects, disconnects)
+		if lastError != "" {
+			fmt.Printf("Last connection error: %v\n", lastError)
+		}
+	}
+	if checked == 0 {
+		fmt.Printf("Notification server: not checked\n\n")
+	} else if healthy {
+		fmt.Printf("Notification server: %v\n\n", server)
+	} else {
+		fmt.Printf("Notification server: NOT RESPONDING (%v, checked %v)\n\n",
+			server, time.Unix(checked, 0).Format("15:04:05"))
+	}
+
+	topics := make([]string, 0, len(stats))
+	for topic := range stats {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	fmt.Printf("%-30s %9s %9s %9s %9s %9s %9s %9s  %s\n", "SUBSCRIPTION", "RECEIVED",
+		"NOTIFIED", "FILTERED", "LIMITED", "ERRORS", "PANICS", "RENDER", "LAST MESSAGE")
+	for _, topic := range topics {
+		s := stats[topic]
+		last := "-"
+		if s[statLastMessage] > 0 {
+			last = time.Unix(s[statLastMessage], 0).Format("2006-01-02 15:04:05")
+		}
+		render := "-"
+		if s[statRendered] > 0 {
+			avg := time.Duration(s[statRenderTime]/s[statRendered]) * time.Microsecond
+			render = avg.String()
+		}
+		fmt.Printf("%-30s %9d %9d %9d %9d %9d %9d %9s  %s\n", topic, s[statReceived],
+			s[statNotified], s[statFiltered], s[statLimited], s[statErrors], s[statPanics],
+			render, last)
+	}
+
+	if len(unread) > 0 {
+		fmt.Printf("\n%-30s %9s\n", "TOPIC", "UNREAD")
+		for topic, count := range unread {
+			fmt.Printf("%-30s %9d\n", topic, count)
+		}
+	}
+	return nil
+}
+
+// Whether a D-Bus call failed because the method does not exist.
+func isUnknownMethod(err error) bool {
+	dbusErr, ok := err.(dbus.Error)
+	return ok && dbusErr.Name == "org.freedesktop.DBus.Error.UnknownMethod"
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// System Service -------------------------------------------------------------
+//
+// In system mode, the program runs once per machine with a single MQTT
+// connection. Instead of talking to a session bus, it sends notifications
+// to helper processes (one per user session) over a local socket.
+// Each helper forwards them to the notifications service of its session.
+
+const defaultSocket = "/run/mqtt-dbus-notify.sock"
+
+// Notifications waiting for a slow session helper.
+const sessionQueueSize = 100
+
+// Maximum time to write a notification to a session helper.
+const sessionWriteTimeout = 5 * time.Second
+
+// A connected session helper. Notifications are queued per helper,
+// so that a helper which does not read does not block the others.
+type sessionHelper struct {
+	conn  net.Conn
+	uid   uint32
+	queue chan *Notification
+}
+
+var sessionListener net.Listener
+var sessionConns = make(map[net.Conn]*sessionHelper)
+var sessionMutex sync.Mutex
+
+// Listen for connections from session helpers.
+func listenSessions() error {
+	config := currentConfig()
+	path := config.Socket
+	// remove a stale socket from a previous run
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// every local user may connect, `session_users` decides who is accepted
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	if len(config.SessionUsers) == 0 {
+		log.Println("WARNING: No session_users configured, only root may connect")
+	}
+
+	sessionListener = l // global
+	log.Printf("Listening for session helpers on %v", path)
+	go acceptSessions(l)
+	return nil
+}
+
+// Accept connections from session helpers until the listener is closed.
+func acceptSessions(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			log.Printf("ERROR: Cannot identify session helper: %v", err)
+			conn.Close()
+			continue
+		}
+		if !sessionUserAllowed(uid) {
+			log.Printf("WARNING: Rejected session helper of uid %d, not in session_users", uid)
+			conn.Close()
+			continue
+		}
+
+		log.Printf("Session helper of uid %d connected", uid)
+		h := &sessionHelper{
+			conn:  conn,
+			uid:   uid,
+			queue: make(chan *Notification, sessionQueueSize),
+		}
+		sessionMutex.Lock()
+		sessionConns[conn] = h
+		sessionMutex.Unlock()
+		go h.write()
+	}
+}
+
+// The user ID of the process on the other end of a unix socket.
+func peerUID(conn net.Conn) (uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("not a unix socket")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
+
+// Whether a user may receive notifications. Root is always allowed,
+// other users only if they are listed in `session_users`.
+func sessionUserAllowed(uid uint32) bool {
+	if uid == 0 {
+		return true
+	}
+	for _, name := range currentConfig().SessionUsers {
+		u, err := user.Lookup(name)
+		if err != nil {
+			continue
+		}
+		if u.Uid == strconv.FormatUint(uint64(uid), 10) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write queued notifications to the helper until it is disconnected.
+func (h *sessionHelper) write() {
+	encoder := json.NewEncoder(h.conn)
+	for n := range h.queue {
+		h.conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		err := encoder.Encode(n)
+		if err != nil {
+			log.Printf("Session helper of uid %d disconnected: %v", h.uid, err)
+			removeSession(h.conn)
+			return
+		}
+	}
+}
+
+// Disconnect a session helper.
+func removeSession(conn net.Conn) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	if h, ok := sessionConns[conn]; ok {
+		close(h.queue)
+		conn.Close()
+		delete(sessionConns, conn)
+	}
+}
+
+// Stop listening and disconnect all session helpers.
+func closeSessions() {
+	if sessionListener == nil {
+		return
+	}
+	sessionListener.Close()
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	for conn, h := range sessionConns {
+		close(h.queue)
+		conn.Close()
+	}
+	sessionConns = make(map[net.Conn]*sessionHelper)
+}
+
+// Queue a notification for all connected session helpers.
+// If the queue of a helper is full, the notification is dropped for it.
+func broadcast(n *Notification) error {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	if len(sessionConns) == 0 {
+		return errors.New("No session helpers connected")
+	}
+	for _, h := range sessionConns {
+		select {
+		case h.queue <- n:
+		default:
+			log.Printf("WARNING: Session helper of uid %d is too slow, dropped notification", h.uid)
+		}
+	}
+	return nil
+}
+
+// Session Helper -------------------------------------------------------------
+
+// Run as session helper: receive notifications from the system service
+// and show them on the session bus.
+func runHelper() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	err = connectDBus()
+	if err != nil {
+		return err
+	}
+	defer disconnectDBus()
+
+	go receiveNotifications()
+
+	// blocks until SIGINT
+	<-signals
+	return nil
+}
+
+// Connect to the system service and show all received notifications.
+// Reconnects if the connection is lost.
+func receiveNotifications() {
+	config := currentConfig()
+	for {
+		conn, err := net.Dial("unix", config.Socket)
+		if err != nil {
+			log.Printf("Failed to connect to system service: %v", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		log.Printf("Connected to system service at %v", config.Socket)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 64*1024), maxPayloadSize)
+		for scanner.Scan() {
+			n := &Notification{}
+			err = json.Unmarshal(scanner.Bytes(), n)
+			if err != nil {
+				log.Printf("ERROR: Invalid notification: %v", err)
+				continue
+			}
+			_, err = notify(n)
+			if err != nil {
+				log.Printf("ERROR: Failed to send notification: %v", err)
+			}
+		}
+
+		conn.Close()
+		log.Println("Disconnected from system service")
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Thresholds -----------------------------------------------------------------
+
+const stateNormal = "normal"
+const stateAbove = "above"
+const stateBelow = "below"
+
+// Configuration for numeric alerts with hysteresis.
+//
+// An alert is raised when the value rises above `above` (or falls below
+// `below`) and cleared when it falls below `above_clear`
+// (or rises above `below_clear`).
+// Without clear values, the alert thresholds are used.
+type ThresholdConfig struct {
+	Above      *float64 `json:"above"`
+	AboveClear *float64 `json:"above_clear"`
+	Below      *float64 `json:"below"`
+	BelowClear *float64 `json:"below_clear"`
+}
+
+// A change of the threshold state, available to templates as `.Threshold`.
+type ThresholdEvent struct {
+	State    string  // "above", "below" or "normal"
+	Previous string  // the state before
+	Value    float64 // the value which caused the change
+	Limit    float64 // the threshold which was crossed
+}
+
+func (e *ThresholdEvent) String() string {
+	if e.State == stateNormal {
+		return fmt.Sprintf("back to normal (%v)", e.Value)
+	}
+	return fmt.Sprintf("%v %v (%v)", e.State, e.Limit, e.Value)
+}
+
+// Calculate the new state for a value. Returns the state
+// and the threshold which caused the change.
+func (c *ThresholdConfig) next(state string, value float64) (string, float64) {
+	switch state {
+	case stateAbove:
+		limit := c.AboveClear
+		if limit == nil {
+			limit = c.Above
+		}
+		if value < *limit {
+			return c.next(stateNormal, value)
+		}
+	case stateBelow:
+		limit := c.BelowClear
+		if limit == nil {
+			limit = c.Below
+		}
+		if value > *limit {
+			return c.next(stateNormal, value)
+		}
+	default:
+		if c.Above != nil && value > *c.Above {
+			return stateAbove, *c.Above
+		}
+		if c.Below != nil && value < *c.Below {
+			return stateBelow, *c.Below
+		}
+		return stateNormal, 0
+	}
+	return state, 0
+}
+
+// Check the value of a message against the thresholds.
+// Returns true if the state changed and a notification should be shown.
+// The change is stored in the template context.
+func (s *Subscription) checkThreshold(ctx *TemplateContext) bool {
+	value, err := s.value(ctx)
+	if err != nil {
+		log.Printf("WARNING: Ignoring non-numeric value on %v: %v", ctx.topic, err)
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.thresholdStates == nil {
+		s.thresholdStates = make(map[string]string)
+	}
+	previous, ok := s.thresholdStates[ctx.topic]
+	if !ok {
+		previous = stateNormal
+	}
+
+	state, limit := s.Threshold.next(previous, value)
+	s.thresholdStates[ctx.topic] = state
+	if state == previous {
+		return false
+	}
+
+	if state == stateNormal {
+		// report the threshold we came back from
+		if previous == stateAbove {
+			limit = *s.Threshold.Above
+		} else {
+			limit = *s.Threshold.Below
+		}
+	}
+	ctx.threshold = &ThresholdEvent{
+		State:    state,
+		Previous: previous,
+		Value:    value,
+		Limit:    limit,
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLS ------------------------------------------------------------------------
+
+// Create the TLS configuration for the broker connection.
+// With `ca_file`, the broker certificate is verified against the
+// certificates from that file instead of the system's CA certificates,
+// e.g. for brokers with a self-signed certificate.
+// With `cert_file` and `key_file`, the client authenticates
+// with a certificate. An encrypted key needs the `key_password`.
+func tlsConfig() (*tls.Config, error) {
+	config := currentConfig()
+	t := &tls.Config{}
+	if config.CAFile != "" {
+		data, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ca_file: no PEM certificates in %v", config.CAFile)
+		}
+		t.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be used together")
+		}
+		cert, err := loadKeyPair(config.CertFile, config.KeyFile, config.KeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load client certificate from %v and %v: %v",
+				config.CertFile, config.KeyFile, err)
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+	return t, nil
+}
+
+// Load the client certificate and its private key,
+// which is decrypted with the password if it is encrypted.
+func loadKeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	if password == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, errors.New("no PEM data in key_file")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return tls.Certificate{}, errors.New("encrypted PKCS #8 keys are not supported, " +
+			"convert the key with `openssl pkcs8 -traditional`")
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	der, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decrypt key_file: %v", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
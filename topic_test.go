@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTopic(t *testing.T) {
+	cases := []struct {
+		index    int
+		expected string
+		ok       bool
+	}{
+		{0, "weather", true},
+		{2, "temperature", true},
+		{3, "", false}, // one past the end, used to panic
+		{4, "", false},
+		{-1, "temperature", true},
+		{-3, "weather", true},
+		{-4, "", false},
+	}
+
+	ctx := NewTemplateContext("weather/berlin/temperature", "", contentTypeText)
+	for _, c := range cases {
+		actual, err := ctx.Topic(c.index)
+		if (err == nil) != c.ok || actual != c.expected {
+			t.Errorf("Topic %d: expected %q (ok: %v), got %q (%v)",
+				c.index, c.expected, c.ok, actual, err)
+		}
+	}
+}
+
+func TestTopicRange(t *testing.T) {
+	cases := []struct {
+		start, end int
+		expected   string
+		ok         bool
+	}{
+		{0, 2, "weather/berlin", true},
+		{1, -1, "berlin", true},
+		{0, 3, "weather/berlin/temperature", true},
+		{-2, 3, "berlin/temperature", true},
+		{1, 1, "", true},
+		{2, 1, "", false},
+		{0, 4, "", false},
+		{-4, 1, "", false},
+	}
+
+	ctx := NewTemplateContext("weather/berlin/temperature", "", contentTypeText)
+	for _, c := range cases {
+		actual, err := ctx.TopicRange(c.start, c.end)
+		if (err == nil) != c.ok || actual != c.expected {
+			t.Errorf("TopicRange %d %d: expected %q (ok: %v), got %q (%v)",
+				c.start, c.end, c.expected, c.ok, actual, err)
+		}
+	}
+}
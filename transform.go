@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Transformations ------------------------------------------------------------
+//
+// A subscription can have a list of `transform` steps which are applied to
+// the payload, in order, before it is used in templates.
+
+const (
+	transformTrim    = "trim"    // remove surrounding whitespace
+	transformJSON    = "json"    // extract a field from a JSON payload
+	transformReplace = "replace" // replace a regular expression
+	transformConvert = "convert" // convert a number between units
+	transformMap     = "map"     // translate values with a map
+)
+
+// One step in the transformation of a payload.
+type TransformStep struct {
+	Type        string            `json:"type"`
+	Field       string            `json:"field"`
+	Pattern     string            `json:"pattern"`
+	Replacement string            `json:"replacement"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Map         string            `json:"map"`
+	Values      map[string]string `json:"values"`
+	regex       *regexp.Regexp
+}
+
+// A unit as factor and offset to convert into the base unit of its kind.
+type unit struct {
+	kind   string
+	factor float64
+	offset float64
+}
+
+// Units for the convert step, by name.
+var units = map[string]unit{
+	"C":   {"temperature", 1, 0},
+	"F":   {"temperature", 5.0 / 9, -32 * 5.0 / 9},
+	"K":   {"temperature", 1, -273.15},
+	"W":   {"power", 1, 0},
+	"kW":  {"power", 1000, 0},
+	"Wh":  {"energy", 1, 0},
+	"kWh": {"energy", 1000, 0},
+	"m":   {"length", 1, 0},
+	"km":  {"length", 1000, 0},
+	"mi":  {"length", 1609.344, 0},
+	"s":   {"time", 1, 0},
+	"min": {"time", 60, 0},
+	"h":   {"time", 3600, 0},
+	"Pa":  {"pressure", 1, 0},
+	"hPa": {"pressure", 100, 0},
+	"bar": {"pressure", 100000, 0},
+}
+
+// Check the step and compile its regular expression.
+func (t *TransformStep) prepare() error {
+	switch t.Type {
+	case transformTrim, transformMap:
+	case transformJSON:
+		if t.Field == "" {
+			return fmt.Errorf("json step needs a field")
+		}
+	case transformReplace:
+		regex, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return err
+		}
+		t.regex = regex
+	case transformConvert:
+		from, ok := units[t.From]
+		if !ok {
+			return fmt.Errorf("unknown unit %q", t.From)
+		}
+		to, ok := units[t.To]
+		if !ok {
+			return fmt.Errorf("unknown unit %q", t.To)
+		}
+		if from.kind != to.kind {
+			return fmt.Errorf("cannot convert %v to %v", t.From, t.To)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", t.Type)
+	}
+	return nil
+}
+
+// Apply the step to a payload.
+func (t *TransformStep) apply(payload string) (string, error) {
+	config := currentConfig()
+	switch t.Type {
+	case transformTrim:
+		return strings.TrimSpace(payload), nil
+	case transformJSON:
+		data, err := decodeJSON(payload)
+		if err != nil {
+			return "", err
+		}
+		value, ok := lookupField(data, t.Field)
+		if !ok {
+			return "", fmt.Errorf("no field %q in payload", t.Field)
+		}
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case transformReplace:
+		if t.regex == nil {
+			if err := t.prepare(); err != nil {
+				return "", err
+			}
+		}
+		return t.regex.ReplaceAllString(payload, t.Replacement), nil
+	case transformConvert:
+		value, err := toFloat(payload)
+		if err != nil {
+			return "", err
+		}
+		from, to := units[t.From], units[t.To]
+		base := value*from.factor + from.offset
+		converted := (base - to.offset) / to.factor
+		// avoid noise like 71.60000000000001
+		converted = math.Round(converted*1e6) / 1e6
+		return strconv.FormatFloat(converted, 'f', -1, 64), nil
+	case transformMap:
+		values := t.Values
+		if t.Map != "" {
+			values = config.Maps[t.Map]
+		}
+		if mapped, ok := values[strings.TrimSpace(payload)]; ok {
+			return mapped, nil
+		}
+		return payload, nil
+	}
+	return "", fmt.Errorf("unknown transform type %q", t.Type)
+}
+
+// Apply all transform steps of the subscription to a payload.
+func (s *Subscription) transform(payload string) (string, error) {
+	var err error
+	for i, step := range s.Transform {
+		payload, err = step.apply(payload)
+		if err != nil {
+			return "", fmt.Errorf("transform[%d] (%v): %v", i, step.Type, err)
+		}
+	}
+	return payload, nil
+}
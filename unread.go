@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+)
+
+// Unread ---------------------------------------------------------------------
+
+// Number of notifications per topic since the user last interacted with one.
+var unread = make(map[string]uint32)
+
+// Topic for each notification we have created.
+var notifiedTopics = make(map[notificationRef]string)
+
+var unreadMutex sync.Mutex
+
+// Get the number of unread notifications for the given topic.
+func getUnread(topic string) uint32 {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	return unread[topic]
+}
+
+// Count a delivered notification.
+func addUnread(topic string, id notificationRef) {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	unread[topic]++
+	notifiedTopics[id] = topic
+}
+
+// Reset the unread count for the topic of the given notification.
+// Called when the user clicks or dismisses a notification.
+func markRead(id notificationRef) {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	topic, ok := notifiedTopics[id]
+	if ok {
+		delete(unread, topic)
+	}
+}
+
+// Forget about a notification which is no longer displayed.
+func forgetNotification(id notificationRef) {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	delete(notifiedTopics, id)
+}
+
+// Get a copy of all unread counts.
+func unreadCounts() map[string]uint32 {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	counts := make(map[string]uint32, len(unread))
+	for topic, n := range unread {
+		counts[topic] = n
+	}
+	return counts
+}
+
+// Whether the notification with the given ID is currently displayed.
+func isDisplayed(id notificationRef) bool {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	_, ok := notifiedTopics[id]
+	return ok
+}
+
+// Number of our notifications which are currently displayed.
+func displayedCount() int {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	return len(notifiedTopics)
+}
+
+// Our displayed notifications for the given topic.
+func displayedFor(topic string) []notificationRef {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+	ids := make([]notificationRef, 0)
+	for id, t := range notifiedTopics {
+		if t == topic {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhooks -------------------------------------------------------------------
+
+const webhookTimeout = 10 * time.Second
+
+// An HTTP request which is sent for each message of a subscription.
+// URL, header values and body are templates.
+type Webhook struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+	templates templateCache     `json:"-"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Render the request for a message and send it in the background.
+func (w *Webhook) send(ctx *TemplateContext) error {
+	method := w.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	u, err := w.templates.render("url", w.URL, ctx)
+	if err != nil {
+		return err
+	}
+	body, err := w.templates.render("body", w.Body, ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSpace(u), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, raw := range w.Headers {
+		value, err := w.templates.render("header:"+key, raw, ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(key, value)
+	}
+
+	go func() {
+		err := doRequest(req)
+		if err != nil {
+			log.Printf("ERROR: Webhook %v %v failed: %v", req.Method, req.URL, err)
+		}
+	}()
+	return nil
+}
+
+// Send an HTTP request and check the response status.
+func doRequest(req *http.Request) error {
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Unexpected status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// Workers --------------------------------------------------------------------
+//
+// Incoming messages are processed by a fixed number of workers,
+// so that a slow notification does not block the MQTT client.
+// All messages for one topic go to the same worker and are processed in
+// the order they arrived; different topics are processed in parallel.
+
+const workerCount = 8
+
+// Number of messages a worker can queue before the sender blocks,
+// unless set with `queue_depth`.
+const defaultQueueDepth = 100
+
+// Default for `shutdown_grace`, in seconds.
+const defaultShutdownGrace = 5
+
+var workerQueues []chan queuedMessage
+var startWorkersOnce sync.Once
+
+// Messages which are queued or being processed.
+var pending sync.WaitGroup
+var draining bool
+var drainDropped = 0
+var drainMutex sync.Mutex
+
+// Start the worker goroutines.
+func startWorkers() {
+	config := currentConfig()
+	depth := defaultQueueDepth
+	if config.QueueDepth > 0 {
+		depth = config.QueueDepth
+	}
+
+	workerQueues = make([]chan queuedMessage, workerCount)
+	for i := range workerQueues {
+		queue := make(chan queuedMessage, depth)
+		workerQueues[i] = queue
+		go func() {
+			for m := range queue {
+				dispatch(m)
+				pending.Done()
+			}
+		}()
+	}
+}
+
+// Hand a message to the worker for its topic.
+func enqueue(m queuedMessage) {
+	if *observeMode {
+		observe(m.Topic, m.Payload)
+		return
+	}
+	startWorkersOnce.Do(startWorkers)
+
+	drainMutex.Lock()
+	if draining {
+		drainDropped++
+		drainMutex.Unlock()
+		return
+	}
+	pending.Add(1)
+	drainMutex.Unlock()
+
+	h := fnv.New32a()
+	h.Write([]byte(m.Topic))
+	workerQueues[h.Sum32()%workerCount] <- m
+}
+
+// Stop accepting new messages and wait until the queued messages
+// are processed, at most `shutdown_grace` seconds.
+// Open aggregation windows with `flush_on_exit` are shown as well.
+func drain() {
+	config := currentConfig()
+	drainMutex.Lock()
+	draining = true
+	drainMutex.Unlock()
+
+	grace := time.Duration(defaultShutdownGrace) * time.Second
+	if config.ShutdownGrace != nil {
+		grace = time.Duration(*config.ShutdownGrace) * time.Second
+	}
+	if grace <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		for _, sub := range config.Subscriptions {
+			if sub.Aggregate != nil && sub.Aggregate.FlushOnExit {
+				sub.flushAggregations()
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		log.Printf("WARNING: Shutdown grace period of %v expired, messages may be lost", grace)
+	}
+
+	drainMutex.Lock()
+	defer drainMutex.Unlock()
+	if drainDropped > 0 {
+		log.Printf("Dropped %d messages while shutting down", drainDropped)
+	}
+}